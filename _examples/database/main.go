@@ -51,7 +51,7 @@ func main() {
 		{Id: "book1", Title: "hello"},
 		{Id: "book2", Title: "hello world"},
 	}
-	err = crud.Create(ctx, &seedBooks, true)
+	_, err = crud.Create(ctx, &seedBooks, true)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -81,7 +81,7 @@ func main() {
 
 	// update
 	want := Book{Id: "book1", Title: "hello ==--updated--=="}
-	err = crud.Update(ctx, &want)
+	_, err = crud.Update(ctx, &want, true)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -91,7 +91,7 @@ func main() {
 		{Id: "book1", Title: "hello --upserted--"},
 		{Id: "book2", Title: "hello world"},
 	}
-	err = crud.Upsert(ctx, &upsertedBooks)
+	_, err = crud.Upsert(ctx, &upsertedBooks, datastore.UpsertOptions{})
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -101,19 +101,19 @@ func main() {
 		{Id: "book1", Title: "hello --updated--"},
 		{Id: "book2", Title: "hello world --updated--"},
 	}
-	err = crud.UpdateBulk(ctx, &updatedBooks)
+	_, err = crud.UpdateBulk(ctx, &updatedBooks)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
 	// DeleteByPK One
-	err = crud.DeleteByPK(ctx, &[]Book{{Id: "book1"}})
+	_, err = crud.DeleteByPK(ctx, &[]Book{{Id: "book1"}}, false)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
 	// DeleteByPK Multi
-	err = crud.DeleteByPK(ctx, &[]Book{{Id: "book1"}, {Id: "book2"}})
+	_, err = crud.DeleteByPK(ctx, &[]Book{{Id: "book1"}, {Id: "book2"}}, false)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -123,7 +123,7 @@ func main() {
 		return q.Where("id = ?", "book1")
 	}
 
-	if err := crud.DeleteWhere(ctx, &[]Book{{Id: "book1"}}, addWhereDelete); err != nil {
+	if _, err := crud.DeleteWhere(ctx, &[]Book{{Id: "book1"}}, addWhereDelete); err != nil {
 		log.Fatal(err.Error())
 	}
 
@@ -143,10 +143,34 @@ func main() {
 				return err
 			}
 
-			return crud.NewWithTx(tx).Create(ctx, &manyBooks, true)
+			_, err = crud.NewWithTx(tx).Create(ctx, &manyBooks, true)
+			return err
 		},
 	)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+
+	// Fixtures: seeding from yaml/json instead of by hand
+	fixtures, err := datastore.NewFixtures(db, (*Book)(nil), (*Dictionary)(nil))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	err = fixtures.Reset(ctx) // empties the tables
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	err = fixtures.LoadBytes(ctx, []byte(`
+books:
+  - id: "{{ randomID 8 }}"
+    title: hello
+dictionaries:
+  - id: oxford1
+    title: Oxford
+`))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 }
@@ -0,0 +1,35 @@
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// cacheMap is the destination map of ICache.GetMany
+type cacheMap struct {
+	m reflect.Value
+}
+
+// newCacheMap checks destMapPtr is a pointer to a map[string]T, creating the map if nil
+func newCacheMap(destMapPtr any) (cacheMap, error) {
+	v := reflect.ValueOf(destMapPtr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Map || v.Elem().Type().Key().Kind() != reflect.String {
+		return cacheMap{}, fmt.Errorf("destination must be a non-nil pointer to a map[string]T, got %T", destMapPtr)
+	}
+
+	m := v.Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	return cacheMap{m: m}, nil
+}
+
+// set sets key to the value decoded by decode, into a pointer to a new value of the map
+func (c cacheMap) set(key string, decode func(vPtr any) error) error {
+	v := reflect.New(c.m.Type().Elem())
+	if err := decode(v.Interface()); err != nil {
+		return err
+	}
+	c.m.SetMapIndex(reflect.ValueOf(key).Convert(c.m.Type().Key()), v.Elem())
+	return nil
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCacheMany(t *testing.T, cache ICache) {
+	type OK struct {
+		D string
+	}
+	ctx := context.Background()
+
+	err := cache.SetMany(ctx, map[string]any{"many1": OK{D: "a"}, "many2": OK{D: "b"}, "many3": OK{D: "c"}}, 5*time.Second)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// hits & misses
+	{
+		var actual map[string]OK
+		missing, err := cache.GetMany(ctx, []string{"many1", "many4", "many2"}, &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, map[string]OK{"many1": {D: "a"}, "many2": {D: "b"}}, actual)
+		assert.Equal(t, []string{"many4"}, missing)
+	}
+
+	// del
+	{
+		err := cache.DelMany(ctx, "many1", "many3")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		actual := map[string]OK{}
+		missing, err := cache.GetMany(ctx, []string{"many1", "many2", "many3"}, &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, map[string]OK{"many2": {D: "b"}}, actual)
+		assert.Equal(t, []string{"many1", "many3"}, missing)
+	}
+
+	// invalid destination
+	{
+		var actual []OK
+		_, err := cache.GetMany(ctx, []string{"many2"}, &actual)
+		assert.NotNilf(t, err, "expected an error for a destination which isn't a map")
+	}
+}
+
+func TestCache_Many(t *testing.T) {
+	redis := miniredis.RunT(t)
+
+	t.Run("GoCache", func(t *testing.T) {
+		testCacheMany(t, NewGoCache())
+	})
+	t.Run("Rueidis", func(t *testing.T) {
+		testCacheMany(t, NewRueidis([]string{redis.Addr()}, "", true))
+	})
+	t.Run("TieredCache", func(t *testing.T) {
+		cache := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true), time.Minute)
+		defer cache.Close()
+		testCacheMany(t, cache)
+	})
+	t.Run("TypedCache", func(t *testing.T) {
+		ctx := context.Background()
+		cache := NewTypedCache[int](NewGoCache(), "")
+
+		err := cache.SetMany(ctx, map[string]int{"1": 1, "2": 2}, time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		actual, missing, err := cache.GetMany(ctx, []string{"1", "2", "3"})
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, map[string]int{"1": 1, "2": 2}, actual)
+		assert.Equal(t, []string{"3"}, missing)
+
+		err = cache.DelMany(ctx, "1", "2")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "1"))
+	})
+}
@@ -0,0 +1,301 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec (un)marshals the values of a cache. See WithCodec.
+type Codec interface {
+	// Name identifies the codec in the header of the stored values: values are always decoded with the
+	// codec that encoded them (if registered, see RegisterCodec), so the codec of a cache can be changed
+	// safely. Changing what a codec produces requires a new name (eg: 'json/v2').
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, vPtr any) error
+}
+
+var (
+	_ Codec = GobCodec{}
+	_ Codec = JSONCodec{}
+	_ Codec = MsgpackCodec{}
+	_ Codec = (*gzipCodec)(nil)
+	_ Codec = (*snappyCodec)(nil)
+)
+
+// ErrUnknownCodec is returned when decoding a value encoded by a codec which isn't registered
+var ErrUnknownCodec = errors.New("unknown cache codec")
+
+// GobCodec encodes with encoding/gob. It is the default codec.
+type GobCodec struct{}
+
+func (GobCodec) Name() string { return "gob" }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte, vPtr any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(vPtr)
+}
+
+// JSONCodec encodes with encoding/json: readable by other languages, but only the exported fields
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, vPtr any) error {
+	return json.Unmarshal(data, vPtr)
+}
+
+// MsgpackCodec encodes with msgpack: compact & fast
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, vPtr any) error {
+	return msgpack.Unmarshal(data, vPtr)
+}
+
+// gzipCodec compresses the values of its codec larger than minSize bytes
+type gzipCodec struct {
+	codec   Codec
+	minSize int
+}
+
+// Gzip wraps codec, compressing the values larger than minSize bytes (eg: 1024), smaller ones rarely
+// getting smaller. Its name is 'gzip+<name of codec>'.
+func Gzip(codec Codec, minSize int) Codec {
+	return &gzipCodec{codec: codec, minSize: minSize}
+}
+
+// the first byte of the values of the compressing codecs (Gzip & Snappy): compressed or not
+const (
+	rawPayload        byte = 0
+	compressedPayload byte = 1
+)
+
+func (c *gzipCodec) Name() string { return "gzip+" + c.codec.Name() }
+
+func (c *gzipCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil || len(data) <= c.minSize {
+		return append([]byte{rawPayload}, data...), err
+	}
+
+	buf := bytes.NewBuffer([]byte{compressedPayload})
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCodec) Unmarshal(data []byte, vPtr any) error {
+	if len(data) == 0 {
+		return errors.New("gzip codec: empty value")
+	}
+	if data[0] == rawPayload {
+		return c.codec.Unmarshal(data[1:], vPtr)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return fmt.Errorf("gzip codec: %w", err)
+	}
+	defer r.Close()
+
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("gzip codec: %w", err)
+	}
+	return c.codec.Unmarshal(data, vPtr)
+}
+
+// snappyCodec compresses the values of its codec larger than minSize bytes
+type snappyCodec struct {
+	codec   Codec
+	minSize int
+}
+
+// Snappy wraps codec, compressing the values larger than minSize bytes (eg: 1024) like Gzip: faster,
+// but compressing less. Its name is 'snappy+<name of codec>'.
+func Snappy(codec Codec, minSize int) Codec {
+	return &snappyCodec{codec: codec, minSize: minSize}
+}
+
+func (c *snappyCodec) Name() string { return "snappy+" + c.codec.Name() }
+
+func (c *snappyCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil || len(data) <= c.minSize {
+		return append([]byte{rawPayload}, data...), err
+	}
+
+	compressed := make([]byte, 1+snappy.MaxEncodedLen(len(data)))
+	compressed[0] = compressedPayload
+	return compressed[:1+len(snappy.Encode(compressed[1:], data))], nil
+}
+
+func (c *snappyCodec) Unmarshal(data []byte, vPtr any) error {
+	if len(data) == 0 {
+		return errors.New("snappy codec: empty value")
+	}
+	if data[0] == rawPayload {
+		return c.codec.Unmarshal(data[1:], vPtr)
+	}
+
+	data, err := snappy.Decode(nil, data[1:])
+	if err != nil {
+		return fmt.Errorf("snappy codec: %w", err)
+	}
+	return c.codec.Unmarshal(data, vPtr)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		GobCodec{}.Name():     GobCodec{},
+		JSONCodec{}.Name():    JSONCodec{},
+		MsgpackCodec{}.Name(): MsgpackCodec{},
+	}
+)
+
+// RegisterCodec registers a custom codec, to decode the values it encoded. The built-in codecs (&
+// their Gzip & Snappy wrappers) are registered.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+func lookupCodec(name string) (Codec, error) {
+	if inner, ok := strings.CutPrefix(name, "gzip+"); ok {
+		codec, err := lookupCodec(inner)
+		if err != nil {
+			return nil, err
+		}
+		return Gzip(codec, 0), nil
+	}
+	if inner, ok := strings.CutPrefix(name, "snappy+"); ok {
+		codec, err := lookupCodec(inner)
+		if err != nil {
+			return nil, err
+		}
+		return Snappy(codec, 0), nil
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if codec, ok := codecs[name]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCodec, name)
+}
+
+// codecMagic starts the header of the encoded values, followed by the header version, & the length
+// & name of the codec. It can't start a gob, json or msgpack value encoded without header (before
+// codecs, see Marshal).
+var codecMagic = []byte{0xfa, 0xdc}
+
+const codecHeaderVersion byte = 1
+
+// cacheCodec encodes the values of a cache, with a header
+type cacheCodec struct {
+	codec Codec // nil: the deprecated Marshal & Unmarshal globals, or gob, without header
+}
+
+func (c cacheCodec) marshal(v any) ([]byte, error) {
+	if c.codec == nil {
+		if Marshal != nil {
+			return Marshal(v)
+		}
+		return GobCodec{}.Marshal(v)
+	}
+
+	name := c.codec.Name()
+	if len(name) > 255 {
+		return nil, fmt.Errorf("codec name %q is too long", name)
+	}
+
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, codecMagic...), codecHeaderVersion, byte(len(name)))
+	return append(append(header, name...), data...), nil
+}
+
+func (c cacheCodec) unmarshal(data []byte, vPtr any) error {
+	if !bytes.HasPrefix(data, codecMagic) {
+		// encoded without header
+		if Unmarshal != nil {
+			return Unmarshal(data, vPtr)
+		}
+		return GobCodec{}.Unmarshal(data, vPtr)
+	}
+
+	data = data[len(codecMagic):]
+	if len(data) < 2 || data[0] != codecHeaderVersion || len(data) < 2+int(data[1]) {
+		return errors.New("invalid cache value header")
+	}
+	name, data := string(data[2:2+int(data[1])]), data[2+int(data[1]):]
+
+	codec := c.codec
+	if codec == nil || codec.Name() != name {
+		var err error
+		if codec, err = lookupCodec(name); err != nil {
+			return err
+		}
+	}
+	return codec.Unmarshal(data, vPtr)
+}
+
+// CacheOption customises a cache (GoCache, Rueidis) at its creation
+type CacheOption func(opts *cacheOptions)
+
+type cacheOptions struct {
+	codec Codec
+}
+
+func newCacheOptions(opts []CacheOption) cacheOptions {
+	o := cacheOptions{codec: GobCodec{}}
+	if Marshal != nil || Unmarshal != nil {
+		o.codec = nil
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCodec sets the codec of the values (gob by default), eg: WithCodec(Gzip(MsgpackCodec{}, 1024))
+func WithCodec(codec Codec) CacheOption {
+	return func(opts *cacheOptions) {
+		opts.codec = codec
+	}
+}
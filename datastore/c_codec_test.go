@@ -0,0 +1,110 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type customCodec struct {
+	JSONCodec
+}
+
+func (customCodec) Name() string { return "custom" }
+
+func TestCodec(t *testing.T) {
+	type OK struct {
+		D string
+		N int
+	}
+	ctx := context.Background()
+	large := OK{D: strings.Repeat("tracy ", 500), N: 1}
+
+	// round trips
+	for _, codec := range []Codec{GobCodec{}, JSONCodec{}, MsgpackCodec{}, Gzip(JSONCodec{}, 1024), Gzip(MsgpackCodec{}, 0),
+		Snappy(JSONCodec{}, 1024), Snappy(MsgpackCodec{}, 0),
+	} {
+		cache := NewGoCache(WithCodec(codec))
+
+		for _, expected := range []OK{{D: "tracy", N: 42}, large} {
+			err := cache.Set(ctx, "key", expected, 5*time.Second)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+			var actual OK
+			err = cache.Get(ctx, "key", &actual)
+			assert.Equalf(t, nil, err, "%s: expected %+v but got: %+v", codec.Name(), nil, err)
+			assert.Equalf(t, expected, actual, "%s: values differ", codec.Name())
+		}
+	}
+
+	// large values are compressed
+	{
+		plain, _ := cacheCodec{codec: JSONCodec{}}.marshal(large)
+		compressed, _ := cacheCodec{codec: Gzip(JSONCodec{}, 1024)}.marshal(large)
+		assert.Less(t, len(compressed), len(plain)/10)
+		compressed, _ = cacheCodec{codec: Snappy(JSONCodec{}, 1024)}.marshal(large)
+		assert.Less(t, len(compressed), len(plain)/10)
+	}
+
+	// values are decoded with the codec which encoded them
+	{
+		redis := miniredis.RunT(t)
+		jsonCache := NewRueidis([]string{redis.Addr()}, "", true, WithCodec(Gzip(JSONCodec{}, 0)))
+		msgpackCache := NewRueidis([]string{redis.Addr()}, "", true, WithCodec(MsgpackCodec{}))
+
+		err := jsonCache.Set(ctx, "migrating", OK{D: "tracy"}, 5*time.Second)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		var actual OK
+		err = msgpackCache.Get(ctx, "migrating", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, OK{D: "tracy"}, actual)
+
+		snappyCache := NewRueidis([]string{redis.Addr()}, "", true, WithCodec(Snappy(GobCodec{}, 0)))
+		err = snappyCache.Set(ctx, "migrating", OK{D: "snappy"}, 5*time.Second)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		err = msgpackCache.Get(ctx, "migrating", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, OK{D: "snappy"}, actual)
+
+		// values without header (before codecs) are gob
+		legacy, _ := GobCodec{}.Marshal(OK{D: "legacy"})
+		redis.Set("legacy", string(legacy))
+		err = msgpackCache.Get(ctx, "legacy", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, OK{D: "legacy"}, actual)
+	}
+
+	// custom codecs must be registered to be decoded by other caches
+	{
+		data, _ := cacheCodec{codec: customCodec{}}.marshal(OK{D: "custom"})
+
+		var actual OK
+		err := cacheCodec{codec: GobCodec{}}.unmarshal(data, &actual)
+		assert.ErrorIs(t, err, ErrUnknownCodec)
+
+		RegisterCodec(customCodec{})
+		err = cacheCodec{codec: GobCodec{}}.unmarshal(data, &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, OK{D: "custom"}, actual)
+	}
+
+	// the deprecated globals still apply to the caches created without codec
+	{
+		Marshal, Unmarshal = json.Marshal, json.Unmarshal
+		defer func() { Marshal, Unmarshal = nil, nil }()
+
+		data, err := NewGoCache().Marshal(OK{D: "global"})
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, `{"D":"global","N":0}`, string(data))
+
+		data, err = NewGoCache(WithCodec(GobCodec{})).Marshal(OK{D: "global"})
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, codecMagic, data[:len(codecMagic)])
+	}
+}
@@ -0,0 +1,44 @@
+package datastore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/otyang/go-pkg/datastore/cachetest"
+)
+
+func TestGoCache_Conformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) datastore.ICache {
+		return datastore.NewGoCache()
+	})
+}
+
+func TestRueidis_Conformance(t *testing.T) {
+	redis := miniredis.RunT(t)
+	sleep := cachetest.WithSleep(func(d time.Duration) {
+		redis.FastForward(d)
+	})
+
+	cachetest.Run(t, func(t *testing.T) datastore.ICache {
+		cache := datastore.NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:")
+		t.Cleanup(func() { _ = cache.Close() })
+		return cache
+	}, sleep)
+}
+
+func TestTieredCache_Conformance(t *testing.T) {
+	redis := miniredis.RunT(t)
+	sleep := cachetest.WithSleep(func(d time.Duration) {
+		time.Sleep(d)
+		redis.FastForward(d)
+	})
+
+	cachetest.Run(t, func(t *testing.T) datastore.ICache {
+		l2 := datastore.NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:")
+		cache := datastore.NewTieredCache(datastore.NewGoCache(), l2, time.Minute)
+		t.Cleanup(func() { _ = cache.Close() })
+		return cache
+	}, sleep)
+}
@@ -3,30 +3,50 @@ package datastore
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"errors"
+	"strings"
+	"sync"
 	"time"
 
 	pgc "github.com/patrickmn/go-cache"
 )
 
 var (
-	_                  ICache = (*GoCache)(nil)
-	ErrNotFoundGoCache        = errors.New("item not found")
+	_                  ICache    = (*GoCache)(nil)
+	_                  lockStore = (*GoCache)(nil)
+	ErrNotFoundGoCache           = errors.New("item not found")
 )
 
 type GoCache struct {
 	client *pgc.Cache
+	codec  cacheCodec
+
+	// serialises the writes, for the conditional ones to be atomic
+	writeMu sync.Mutex
+
+	// index of the tagged keys
+	mu      sync.Mutex
+	tags    map[string]map[string]struct{}
+	keyTags map[string][]string
 }
 
-func NewGoCache() *GoCache {
-	defaultExpiration := 8 * time.Second
+// NewGoCache returns an in-process cache, eg: NewGoCache(WithCodec(JSONCodec{}))
+func NewGoCache(opts ...CacheOption) *GoCache {
+	// a ttl of 0 never expires, like on redis
 	cleanupInterval := 1 * time.Minute
 
-	c := pgc.New(defaultExpiration, cleanupInterval)
-	return &GoCache{
-		client: c,
+	c := pgc.New(pgc.NoExpiration, cleanupInterval)
+	s := &GoCache{
+		client:  c,
+		codec:   cacheCodec{codec: newCacheOptions(opts).codec},
+		tags:    make(map[string]map[string]struct{}),
+		keyTags: make(map[string][]string),
 	}
+	// deleted or expired keys leave their tags
+	c.OnEvicted(func(key string, _ any) {
+		s.untag(key)
+	})
+	return s
 }
 
 func (s *GoCache) Has(ctx context.Context, key string) bool {
@@ -47,22 +67,258 @@ func (s *GoCache) Get(ctx context.Context, key string, dest any) error {
 	return s.Unmarshal(v, dest)
 }
 
-func (s *GoCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+func (s *GoCache) Set(ctx context.Context, key string, val any, ttl time.Duration, opts ...SetOption) error {
 	bytes, err := s.Marshal(val)
 	if err != nil {
 		return err
 	}
-	s.client.Set(key, bytes, ttl)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.set(key, bytes, ttl, newSetOptions(opts).tags)
 	return nil
 }
 
+func (s *GoCache) set(key string, bytes []byte, ttl time.Duration, tags []string) {
+	// overwritten keys don't keep their previous tags
+	s.untag(key)
+	s.client.Set(key, bytes, ttl)
+	s.tag(key, tags)
+}
+
 func (s *GoCache) Del(ctx context.Context, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.client.Delete(key)
+	return nil
+}
+
+func (s *GoCache) SetIfAbsent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	return s.setIf(key, val, ttl, func(current []byte, found bool) bool {
+		return !found
+	})
+}
+
+func (s *GoCache) SetIfPresent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	return s.setIf(key, val, ttl, func(current []byte, found bool) bool {
+		return found
+	})
+}
+
+func (s *GoCache) CompareAndSwap(ctx context.Context, key string, oldVal, newVal any, ttl time.Duration) (bool, error) {
+	oldBytes, err := s.Marshal(oldVal)
+	if err != nil {
+		return false, err
+	}
+	return s.setIf(key, newVal, ttl, func(current []byte, found bool) bool {
+		return found && bytes.Equal(current, oldBytes)
+	})
+}
+
+// setIf sets key if cond holds for its current value
+func (s *GoCache) setIf(key string, val any, ttl time.Duration, cond func(current []byte, found bool) bool) (bool, error) {
+	bytes, err := s.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	raw, found := s.client.Get(key)
+	current, _ := raw.([]byte)
+	if !cond(current, found) {
+		return false, nil
+	}
+
+	s.set(key, bytes, ttl, nil)
+	return true, nil
+}
+
+func (s *GoCache) GetAndDelete(ctx context.Context, key string, dest any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.Get(ctx, key, dest); err != nil {
+		return err
+	}
 	s.client.Delete(key)
 	return nil
 }
 
+func (s *GoCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, 1, ttl)
+}
+
+func (s *GoCache) Decr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, -1, ttl)
+}
+
+func (s *GoCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var n int64
+	if ttl <= 0 {
+		ttl = pgc.NoExpiration
+	}
+
+	raw, expiration, found := s.client.GetWithExpiration(key)
+	remaining := time.Until(expiration)
+	// expiring right now: restarted (a remaining ttl <= 0 would never expire)
+	if found && (expiration.IsZero() || remaining > 0) {
+		current, ok := raw.(int64)
+		if !ok {
+			return 0, errors.New("stored value expected to be a counter. something else stored")
+		}
+
+		// keep the ttl of the counter
+		n, ttl = current, pgc.NoExpiration
+		if !expiration.IsZero() {
+			ttl = remaining
+		}
+	}
+
+	n += delta
+	s.client.Set(key, n, ttl)
+	return n, nil
+}
+
+// acquireLock stores the lock as its token, unencoded
+func (s *GoCache) acquireLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.client.Add(key, token, ttl) == nil, nil
+}
+
+func (s *GoCache) extendLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if current, found := s.client.Get(key); !found || current != token {
+		return false, nil
+	}
+	s.client.Set(key, token, ttl)
+	return true, nil
+}
+
+func (s *GoCache) releaseLock(ctx context.Context, key, token string) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if current, found := s.client.Get(key); !found || current != token {
+		return false, nil
+	}
+	s.client.Delete(key)
+	return true, nil
+}
+
+func (s *GoCache) InvalidateTag(ctx context.Context, tags ...string) error {
+	var keys []string
+	s.mu.Lock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.DelMany(ctx, keys...)
+}
+
+func (s *GoCache) DelPrefix(ctx context.Context, prefix string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	for key := range s.client.Items() {
+		if strings.HasPrefix(key, prefix) {
+			s.client.Delete(key)
+		}
+	}
+	return nil
+}
+
+func (s *GoCache) tag(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	s.keyTags[key] = tags
+}
+
+func (s *GoCache) untag(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range s.keyTags[key] {
+		delete(s.tags[tag], key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+	delete(s.keyTags, key)
+}
+
+func (s *GoCache) GetMany(ctx context.Context, keys []string, destMapPtr any) ([]string, error) {
+	dest, err := newCacheMap(destMapPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		err := dest.set(key, func(vPtr any) error {
+			return s.Get(ctx, key, vPtr)
+		})
+		if errors.Is(err, ErrNotFoundGoCache) {
+			missing = append(missing, key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func (s *GoCache) SetMany(ctx context.Context, items map[string]any, ttl time.Duration) error {
+	for key, val := range items {
+		if err := s.Set(ctx, key, val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GoCache) DelMany(ctx context.Context, keys ...string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	for _, key := range keys {
+		s.client.Delete(key)
+	}
+	return nil
+}
+
 func (s *GoCache) Clear(ctx context.Context) error {
 	s.client.Flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = make(map[string]map[string]struct{})
+	s.keyTags = make(map[string][]string)
 	return nil
 }
 
@@ -71,17 +327,9 @@ func (s *GoCache) Close() error {
 }
 
 func (s *GoCache) Marshal(v any) ([]byte, error) {
-	if Marshal != nil {
-		return Marshal(v)
-	}
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(v)
-	return buf.Bytes(), err
+	return s.codec.marshal(v)
 }
 
 func (s *GoCache) Unmarshal(data []byte, vPtr any) error {
-	if Unmarshal != nil {
-		return Unmarshal(data, vPtr)
-	}
-	return gob.NewDecoder(bytes.NewReader(data)).Decode(vPtr)
+	return s.codec.unmarshal(data, vPtr)
 }
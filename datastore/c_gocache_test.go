@@ -36,6 +36,8 @@ func TestGoCache(t *testing.T) {
 		assert.Equal(t, ErrNotFoundGoCache, err, "should return an error")
 	}
 
+	_ = cache.Set(ctx, "forever", OK{D: "tracy"}, 0)
+
 	// getting item after expiry
 	time.Sleep(9 * time.Second)
 	{
@@ -45,4 +47,27 @@ func TestGoCache(t *testing.T) {
 		assert.Equalf(t, expects2, actual2, "expected nil but got %#v", actual2)
 		assert.Equalf(t, ErrNotFoundGoCache, err, "expected nil but got %s", err)
 	}
+
+	// a ttl of 0 never expires
+	{
+		var actual3 OK
+		err := cache.Get(ctx, "forever", &actual3)
+		assert.Equalf(t, nil, err, "expected nil but got %s", err)
+		assert.Equalf(t, expected, actual3, "expected %+v but got: %+v", expected, actual3)
+	}
+}
+
+func TestGoCache_IncrBy_Expiring(t *testing.T) {
+	ctx := context.Background()
+	cache := NewGoCache()
+
+	// counters incremented as they expire still expire (eg: rate limit windows)
+	deadline := time.Now().Add(20 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		_, err := cache.IncrBy(ctx, "counter", 1, time.Microsecond)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+
+	time.Sleep(time.Millisecond)
+	assert.False(t, cache.Has(ctx, "counter"), "expected the counter to expire")
 }
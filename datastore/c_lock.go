@@ -0,0 +1,242 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/otyang/go-pkg/utils"
+)
+
+var _ Locker = (*CacheLocker)(nil)
+
+// lockStore stores the locks of a CacheLocker: a key holding the token of its owner, expiring after ttl.
+// Extending & releasing only succeed with the token of the owner.
+type lockStore interface {
+	acquireLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	extendLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	releaseLock(ctx context.Context, key, token string) (bool, error)
+}
+
+// CacheLocker is a Locker on cache keys: on redis (NewRedisLocker) shared by the replicas, or in
+// process (NewMemoryLocker) eg: for tests.
+//
+// A lock expires after ttl, so that the lock of a crashed replica becomes free again, but is extended
+// in the background every ttl/3 while held (until Unlock). A lock not extended for ttl (eg: redis
+// unreachable) may be taken by another replica: it is lost, closing its Lost channel. A lost lock
+// isn't held anymore: TryLock acquires it anew (if free) & Unlock returns ErrLockNotHeld.
+//
+// Unlike the other Lockers, TryLock doesn't grant a key this locker holds again: the callers sharing
+// a locker exclude each other too. Use Lost to check a held lock.
+//
+//	if err := locker.Lock(ctx, "payment:"+id); err != nil {
+//		return err
+//	}
+//	defer locker.Unlock(ctx, "payment:"+id)
+//
+//	select {
+//	case <-locker.Lost("payment:" + id):
+//		return errors.New("payment lock lost") // another replica may be processing it
+//	default:
+//		return capture(ctx, id)
+//	}
+type CacheLocker struct {
+	store lockStore
+	ttl   time.Duration
+
+	mu   sync.Mutex
+	held map[string]*heldLock
+}
+
+// heldLock is a lock held by a CacheLocker, extended until stop is called. It is held until deadline
+// at least (guarded by CacheLocker.mu), & lost is closed once it isn't held anymore.
+type heldLock struct {
+	token    string
+	stop     context.CancelFunc
+	deadline time.Time
+	lost     chan struct{}
+}
+
+// NewRedisLocker returns a Locker on redis keys, locks expiring after ttl when not extended
+func NewRedisLocker(cache *Rueidis, ttl time.Duration) (*CacheLocker, error) {
+	return newCacheLocker(cache, ttl)
+}
+
+// NewMemoryLocker returns a Locker on the keys of an in-process cache, locks expiring after ttl
+// when not extended. Its locks are only shared by the lockers of the same cache.
+func NewMemoryLocker(cache *GoCache, ttl time.Duration) (*CacheLocker, error) {
+	return newCacheLocker(cache, ttl)
+}
+
+func newCacheLocker(store lockStore, ttl time.Duration) (*CacheLocker, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lock ttl must be positive, got %s", ttl)
+	}
+	return &CacheLocker{store: store, ttl: ttl, held: make(map[string]*heldLock)}, nil
+}
+
+// TryLock calls the store without holding l.mu: the keys of a locker don't wait for each other
+func (l *CacheLocker) TryLock(ctx context.Context, key string) (bool, error) {
+	key = lockKey(key)
+
+	// held by another caller of this locker
+	if _, ok := l.heldLock(key); ok {
+		return false, nil
+	}
+
+	start := time.Now()
+	token := utils.RandomID(20)
+	locked, err := l.store.acquireLock(ctx, key, token, l.ttl)
+	if err != nil || !locked {
+		return false, err
+	}
+
+	extendCtx, stop := context.WithCancel(context.Background())
+	h := &heldLock{token: token, stop: stop, deadline: start.Add(l.ttl), lost: make(chan struct{})}
+
+	l.mu.Lock()
+	if lost, ok := l.held[key]; ok {
+		// its key was deleted from the store (eg: flushed)
+		l.forget(key, lost)
+	}
+	l.held[key] = h
+	l.mu.Unlock()
+
+	go l.extend(extendCtx, key, h)
+	return true, nil
+}
+
+// heldLock returns the lock of key, if held & not past its deadline (lost then)
+func (l *CacheLocker) heldLock(key string) (*heldLock, bool) {
+	l.mu.Lock()
+	h, ok := l.held[key]
+	expired := ok && !time.Now().Before(h.deadline)
+	l.mu.Unlock()
+
+	if expired {
+		l.lose(key, h)
+		return nil, false
+	}
+	return h, ok
+}
+
+// extended records the lock h extended (by a call started at start)
+func (l *CacheLocker) extended(h *heldLock, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if deadline := start.Add(l.ttl); deadline.After(h.deadline) {
+		h.deadline = deadline
+	}
+}
+
+// lose forgets the lock h of key, lost
+func (l *CacheLocker) lose(key string, h *heldLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.forget(key, h)
+}
+
+// forget stops extending the lock h of key & closes its lost channel. l.mu must be held.
+func (l *CacheLocker) forget(key string, h *heldLock) {
+	if l.held[key] != h {
+		return
+	}
+	delete(l.held, key)
+	h.stop()
+	close(h.lost)
+}
+
+// extend extends the lock h of key every ttl/3, until stopped or the lock is lost: failed attempts
+// (eg: redis unreachable) are retried until the deadline of the lock.
+func (l *CacheLocker) extend(ctx context.Context, key string, h *heldLock) {
+	timer := time.NewTimer(l.ttl / 3)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		l.mu.Lock()
+		deadline := h.deadline
+		l.mu.Unlock()
+
+		start := time.Now()
+		if !start.Before(deadline) {
+			l.lose(key, h)
+			return
+		}
+
+		// an attempt outlasting the lock is pointless
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		extended, err := l.store.extendLock(attemptCtx, key, h.token, l.ttl)
+		cancel()
+
+		switch {
+		case err == nil && !extended:
+			l.lose(key, h)
+			return
+		case err == nil:
+			l.extended(h, start)
+			timer.Reset(l.ttl / 3)
+		default:
+			// retried on the next tick, or when the lock expires
+			wait := l.ttl / 3
+			if untilDeadline := time.Until(deadline); untilDeadline < wait {
+				wait = untilDeadline
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+func (l *CacheLocker) Lock(ctx context.Context, key string) error {
+	interval := l.ttl / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return pollLock(ctx, l, key, interval)
+}
+
+func (l *CacheLocker) Unlock(ctx context.Context, key string) error {
+	key = lockKey(key)
+
+	l.mu.Lock()
+	h, ok := l.held[key]
+	if ok {
+		l.forget(key, h)
+	}
+	l.mu.Unlock()
+
+	if !ok {
+		return ErrLockNotHeld
+	}
+
+	released, err := l.store.releaseLock(ctx, key, h.token)
+	if err == nil && !released {
+		err = ErrLockNotHeld
+	}
+	return err
+}
+
+// Lost returns a channel closed once the lock of key isn't held anymore: lost, or released by Unlock.
+// It is closed already if the lock isn't held.
+func (l *CacheLocker) Lost(key string) <-chan struct{} {
+	if h, ok := l.heldLock(lockKey(key)); ok {
+		return h.lost
+	}
+
+	lost := make(chan struct{})
+	close(lost)
+	return lost
+}
+
+// lockKey is the cache key of the lock of key
+func lockKey(key string) string {
+	return "datastore:lock:" + key
+}
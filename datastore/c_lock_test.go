@@ -0,0 +1,277 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCacheLocker(t *testing.T, newLocker func() *CacheLocker) {
+	ctx := context.Background()
+	a, b := newLocker(), newLocker()
+
+	// mutual exclusion
+	{
+		locked, err := a.TryLock(ctx, "payment")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equalf(t, true, locked, "expected %+v but got: %+v", true, locked)
+
+		locked, err = a.TryLock(ctx, "payment")
+		assert.Equalf(t, false, locked, "expected the held lock not to be granted again but got: %+v, %+v", locked, err)
+
+		locked, err = b.TryLock(ctx, "payment")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equalf(t, false, locked, "expected %+v but got: %+v", false, locked)
+
+		err = b.Unlock(ctx, "payment")
+		assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+	}
+
+	// Lock waits for the holder to release
+	{
+		acquired := make(chan error, 1)
+		go func() { acquired <- b.Lock(ctx, "payment") }()
+
+		select {
+		case err := <-acquired:
+			t.Fatalf("expected Lock to wait for the holder but got: %+v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		err := a.Unlock(ctx, "payment")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		select {
+		case err := <-acquired:
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		case <-time.After(time.Second):
+			t.Fatal("expected Lock to acquire the released lock")
+		}
+
+		err = b.Unlock(ctx, "payment")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		err = b.Unlock(ctx, "payment")
+		assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+	}
+
+	// callers racing through one locker: a single winner
+	{
+		locker := newLocker()
+		var winners atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if locked, _ := locker.TryLock(ctx, "race"); locked {
+					winners.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equalf(t, int32(1), winners.Load(), "expected %+v but got: %+v", 1, winners.Load())
+
+		err := locker.Unlock(ctx, "race")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+
+	// Lock gives up when ctx is done
+	{
+		_, _ = a.TryLock(ctx, "refund")
+		timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		err := b.Lock(timeoutCtx, "refund")
+		assert.Equalf(t, context.DeadlineExceeded, err, "expected %+v but got: %+v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestMemoryLocker(t *testing.T) {
+	cache := NewGoCache()
+	newLocker := func() *CacheLocker {
+		locker, err := NewMemoryLocker(cache, time.Second)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		return locker
+	}
+
+	testCacheLocker(t, newLocker)
+
+	_, err := NewMemoryLocker(cache, 0)
+	assert.Errorf(t, err, "expected an error for a zero ttl")
+}
+
+func TestMemoryLocker_Extension(t *testing.T) {
+	ctx := context.Background()
+	cache := NewGoCache()
+	a, _ := NewMemoryLocker(cache, 100*time.Millisecond)
+	b, _ := NewMemoryLocker(cache, 100*time.Millisecond)
+
+	// held past its ttl while extended
+	{
+		_, _ = a.TryLock(ctx, "payment")
+		time.Sleep(350 * time.Millisecond)
+
+		locked, err := b.TryLock(ctx, "payment")
+		assert.Equalf(t, false, locked, "expected the extended lock to be held but got: %+v, %+v", locked, err)
+
+		select {
+		case <-a.Lost("payment"):
+			t.Fatal("expected the extended lock to be held")
+		default:
+		}
+	}
+
+	// lost: taken over by another locker
+	{
+		_ = cache.Del(ctx, lockKey("payment"))
+		locked, err := b.TryLock(ctx, "payment")
+		assert.Equalf(t, true, locked, "expected %+v but got: %+v, %+v", true, locked, err)
+
+		locked, err = a.TryLock(ctx, "payment")
+		assert.Equalf(t, false, locked, "expected the lost lock not to be held but got: %+v, %+v", locked, err)
+
+		err = a.Unlock(ctx, "payment")
+		assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+
+		err = b.Unlock(ctx, "payment")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+}
+
+// unreachableStore fails extending the locks once unreachable is set
+type unreachableStore struct {
+	lockStore
+	unreachable atomic.Bool
+}
+
+func (s *unreachableStore) extendLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	if s.unreachable.Load() {
+		return false, errors.New("unreachable")
+	}
+	return s.lockStore.extendLock(ctx, key, token, ttl)
+}
+
+func TestMemoryLocker_Lost(t *testing.T) {
+	ctx := context.Background()
+	cache := NewGoCache()
+	store := &unreachableStore{lockStore: cache}
+	a, _ := newCacheLocker(store, 100*time.Millisecond)
+	b, _ := NewMemoryLocker(cache, 100*time.Millisecond)
+
+	// not held
+	select {
+	case <-a.Lost("payment"):
+	default:
+		t.Fatal("expected the lock not held to be lost")
+	}
+
+	locked, err := a.TryLock(ctx, "payment")
+	assert.Equalf(t, true, locked, "expected %+v but got: %+v, %+v", true, locked, err)
+	lost := a.Lost("payment")
+
+	// extensions failing: lost once expired, & taken over
+	store.unreachable.Store(true)
+	select {
+	case <-lost:
+		t.Fatal("expected the lock to be held until it expires")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("expected the expired lock to be lost")
+	}
+
+	assert.Eventually(t, func() bool {
+		locked, _ := b.TryLock(ctx, "payment")
+		return locked
+	}, time.Second, 10*time.Millisecond)
+
+	err = a.Unlock(ctx, "payment")
+	assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+
+	// released: lost too
+	lost = b.Lost("payment")
+	err = b.Unlock(ctx, "payment")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	select {
+	case <-lost:
+	default:
+		t.Fatal("expected the released lock to be lost")
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	ctx := context.Background()
+	redis := miniredis.RunT(t)
+	cache := NewRueidis([]string{redis.Addr()}, "", true)
+	newLocker := func() *CacheLocker {
+		locker, err := NewRedisLocker(cache, 300*time.Millisecond)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		return locker
+	}
+
+	testCacheLocker(t, newLocker)
+
+	// extended while held (miniredis only expires keys on fast-forwarding)
+	{
+		locker := newLocker()
+		_, _ = locker.TryLock(ctx, "payout")
+		redis.FastForward(250 * time.Millisecond)
+
+		assert.Eventually(t, func() bool {
+			return redis.TTL(lockKey("payout")) > 250*time.Millisecond
+		}, time.Second, 10*time.Millisecond, "expected the lock to be extended")
+
+		err := locker.Unlock(ctx, "payout")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equalf(t, false, redis.Exists(lockKey("payout")), "expected the released lock to be deleted")
+	}
+
+	// expired: lost
+	{
+		locker := newLocker()
+		_, _ = locker.TryLock(ctx, "payout")
+		locker.held[lockKey("payout")].stop()
+		redis.FastForward(time.Second)
+
+		locked, err := newLocker().TryLock(ctx, "payout")
+		assert.Equalf(t, true, locked, "expected %+v but got: %+v, %+v", true, locked, err)
+
+		err = locker.Unlock(ctx, "payout")
+		assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+	}
+}
+
+func TestMemoryLocker_LeaderElection(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	locker, _ := NewMemoryLocker(NewGoCache(), time.Second)
+
+	// confirmed by the lock not being lost, TryLock refusing the held key
+	var elected, revoked atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		RunLeaderElection(ctx, locker, "cron", 10*time.Millisecond, func(ctx context.Context) {
+			elected.Add(1)
+		}, func() {
+			revoked.Add(1)
+		})
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equalf(t, int32(1), elected.Load(), "expected %+v but got: %+v", 1, elected.Load())
+	assert.Equalf(t, int32(0), revoked.Load(), "expected %+v but got: %+v", 0, revoked.Load())
+
+	stop()
+	<-done
+	assert.Equalf(t, int32(1), revoked.Load(), "expected %+v but got: %+v", 1, revoked.Load())
+}
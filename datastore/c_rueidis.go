@@ -1,30 +1,48 @@
 package datastore
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/otyang/go-pkg/utils"
 	"github.com/redis/rueidis"
 	"github.com/redis/rueidis/rueidiscompat"
 )
 
 var (
-	_         ICache = (*Rueidis)(nil)
-	Marshal   func(v any) ([]byte, error)
+	_ ICache     = (*Rueidis)(nil)
+	_ loadLocker = (*Rueidis)(nil)
+	_ lockStore  = (*Rueidis)(nil)
+)
+
+var (
+	// Marshal overrides the encoding of the caches created without codec.
+	//
+	// Deprecated: set per cache & safely with WithCodec.
+	Marshal func(v any) ([]byte, error)
+	// Unmarshal overrides the decoding of the caches created without codec.
+	//
+	// Deprecated: set per cache & safely with WithCodec.
 	Unmarshal func(data []byte, vPtr any) error
 )
 
+// ErrNoNamespace is returned by Rueidis.Clear on a cache without namespace
+var ErrNoNamespace = errors.New("clearing a redis cache requires a namespace (Rueidis.WithNamespace)")
+
 type Rueidis struct {
-	store  rueidis.Client
-	client rueidiscompat.Cmdable
+	store     rueidis.Client
+	client    rueidiscompat.Cmdable
+	codec     cacheCodec
+	namespace string
 }
 
-// NewRueidis establishes a redis-cache connection via redis/Rueidis library
-func NewRueidis(redisURLs []string, password string, disableCache bool) *Rueidis {
+// NewRueidis establishes a redis-cache connection via redis/Rueidis library, eg:
+// NewRueidis(urls, password, false, WithCodec(Gzip(MsgpackCodec{}, 1024)))
+func NewRueidis(redisURLs []string, password string, disableCache bool, cacheOpts ...CacheOption) *Rueidis {
 	opts := rueidis.ClientOption{
 		Username:     "",
 		Password:     password,
@@ -43,37 +61,296 @@ func NewRueidis(redisURLs []string, password string, disableCache bool) *Rueidis
 	return &Rueidis{
 		store:  client,
 		client: rueidiscompat.NewAdapter(client),
+		codec:  cacheCodec{codec: newCacheOptions(cacheOpts).codec},
 	}
 }
 
+// WithNamespace returns the cache prefixing its keys (& tags) with namespace, eg: "sessions:", sharing
+// the connection: closing either closes both. Clear deletes the keys of the namespace only, keeping
+// the other keys of the redis database (eg: the locks of NewRedisLocker, which aren't namespaced).
+func (s *Rueidis) WithNamespace(namespace string) *Rueidis {
+	cp := *s
+	cp.namespace = namespace
+	return &cp
+}
+
+// key returns the redis key of key, in the namespace
+func (s *Rueidis) key(key string) string {
+	return s.namespace + key
+}
+
+func (s *Rueidis) keys(keys []string) []string {
+	if s.namespace == "" {
+		return keys
+	}
+	namespaced := make([]string, len(keys))
+	for i, key := range keys {
+		namespaced[i] = s.key(key)
+	}
+	return namespaced
+}
+
 func (s *Rueidis) Has(ctx context.Context, key string) bool {
-	i, _ := s.client.Exists(ctx, key).Result()
+	i, _ := s.client.Exists(ctx, s.key(key)).Result()
 	return i > 0
 }
 
 func (s *Rueidis) Get(ctx context.Context, key string, dest any) error {
-	val, err := s.client.Cache(time.Second).Get(ctx, key).Bytes()
+	val, err := s.client.Cache(time.Second).Get(ctx, s.key(key)).Bytes()
 	if err != nil {
 		return err
 	}
 	return s.Unmarshal(val, dest)
 }
 
-func (s *Rueidis) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+func (s *Rueidis) Set(ctx context.Context, key string, val any, ttl time.Duration, opts ...SetOption) error {
 	bytes, err := s.Marshal(val)
 	if err != nil {
 		return err
 	}
-	_, err = s.client.SetNX(ctx, key, bytes, ttl).Result()
-	return err
+	if err := s.client.Set(ctx, s.key(key), bytes, ttl).Err(); err != nil {
+		return err
+	}
+	return s.tag(ctx, s.key(key), ttl, newSetOptions(opts).tags)
+}
+
+func (s *Rueidis) SetIfAbsent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	bytes, err := s.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+	return s.client.SetNX(ctx, s.key(key), bytes, ttl).Result()
+}
+
+func (s *Rueidis) SetIfPresent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	bytes, err := s.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+	return s.client.SetXX(ctx, s.key(key), bytes, ttl).Result()
+}
+
+// compareAndSwapScript sets KEYS[1] to ARGV[2] (for ARGV[3] milliseconds, 0 never) if its value is ARGV[1]
+const compareAndSwapScript = `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1`
+
+func (s *Rueidis) CompareAndSwap(ctx context.Context, key string, oldVal, newVal any, ttl time.Duration) (bool, error) {
+	oldBytes, err := s.Marshal(oldVal)
+	if err != nil {
+		return false, err
+	}
+	newBytes, err := s.Marshal(newVal)
+	if err != nil {
+		return false, err
+	}
+
+	swapped, err := s.client.Eval(ctx, compareAndSwapScript, []string{s.key(key)}, oldBytes, newBytes, ttl.Milliseconds()).Int()
+	return swapped == 1, err
+}
+
+func (s *Rueidis) GetAndDelete(ctx context.Context, key string, dest any) error {
+	val, err := s.client.GetDel(ctx, s.key(key)).Bytes()
+	if err != nil {
+		return err
+	}
+	return s.Unmarshal(val, dest)
 }
 
 func (s *Rueidis) Del(ctx context.Context, key string) error {
-	return s.client.Del(ctx, key).Err()
+	return s.client.Del(ctx, s.key(key)).Err()
+}
+
+// incrByScript increments the counter KEYS[1] by ARGV[1], setting its ttl (ARGV[2] milliseconds,
+// 0 never) when new
+const incrByScript = `
+local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return n`
+
+func (s *Rueidis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, 1, ttl)
+}
+
+func (s *Rueidis) Decr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, -1, ttl)
+}
+
+func (s *Rueidis) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	// INCRBY & PEXPIRE in a script: atomic, & in ONE round trip
+	return s.client.Eval(ctx, incrByScript, []string{s.key(key)}, delta, ttl.Milliseconds()).Int64()
+}
+
+// tagScript adds the key ARGV[1] to the tag set KEYS[1], which must live as long as its keys: it
+// expires with its longest-lived key (ARGV[2] milliseconds, 0 never)
+const tagScript = `
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+else
+	local current = redis.call("PTTL", KEYS[1])
+	if existed == 0 or (current >= 0 and current < ttl) then
+		redis.call("PEXPIRE", KEYS[1], ttl)
+	end
+end
+return 1`
+
+// tag adds the (redis) key to the sets of tags
+func (s *Rueidis) tag(ctx context.Context, key string, ttl time.Duration, tags []string) error {
+	for _, tag := range tags {
+		if err := s.client.Eval(ctx, tagScript, []string{s.key(tagKey(tag))}, key, ttl.Milliseconds()).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// popTagScript returns the keys of the tag set KEYS[1], deleting it: a key tagged afterwards lands in
+// a new set, never in one already invalidated. The keys (of any cluster slot) are deleted by the caller.
+const popTagScript = `
+local keys = redis.call("SMEMBERS", KEYS[1])
+redis.call("DEL", KEYS[1])
+return keys`
+
+func (s *Rueidis) InvalidateTag(ctx context.Context, tags ...string) error {
+	_, err := s.invalidateTag(ctx, tags)
+	return err
+}
+
+// invalidateTag deletes the keys of tags, returning them (out of the namespace): the keys deleted
+// until an error
+func (s *Rueidis) invalidateTag(ctx context.Context, tags []string) ([]string, error) {
+	var invalidated []string
+	for _, tag := range tags {
+		keys, err := s.client.Eval(ctx, popTagScript, []string{s.key(tagKey(tag))}).StringSlice()
+		if err != nil {
+			return invalidated, err
+		}
+		if err := s.del(ctx, keys); err != nil {
+			return invalidated, err
+		}
+		for _, key := range keys {
+			invalidated = append(invalidated, strings.TrimPrefix(key, s.namespace))
+		}
+	}
+	return invalidated, nil
+}
+
+// DelPrefix deletes the keys starting with prefix, SCANning the keys (& so the redis server) without
+// blocking it. On a cluster, every node is scanned.
+func (s *Rueidis) DelPrefix(ctx context.Context, prefix string) error {
+	match := globEscaper.Replace(s.key(prefix)) + "*"
+
+	// the replicas are scanned too (their keys being the ones of their master): deleting a key twice
+	// at worst
+	for _, node := range s.store.Nodes() {
+		var cursor uint64
+		for {
+			entry, err := node.Do(ctx, node.B().Scan().Cursor(cursor).Match(match).Count(1000).Build()).AsScanEntry()
+			if err != nil {
+				return err
+			}
+			if err := s.del(ctx, entry.Elements); err != nil {
+				return err
+			}
+
+			if cursor = entry.Cursor; cursor == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Rueidis) GetMany(ctx context.Context, keys []string, destMapPtr any) ([]string, error) {
+	dest, err := newCacheMap(destMapPtr)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	// MGETs grouped by slot
+	values, err := rueidis.MGet(s.store, ctx, s.keys(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		val := values[s.key(key)]
+		if val.IsNil() {
+			missing = append(missing, key)
+			continue
+		}
+
+		b, err := val.AsBytes()
+		if err != nil {
+			return nil, err
+		}
+		if err := dest.set(key, func(vPtr any) error { return s.Unmarshal(b, vPtr) }); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func (s *Rueidis) SetMany(ctx context.Context, items map[string]any, ttl time.Duration) error {
+	cmds := make(rueidis.Commands, 0, len(items))
+	for key, val := range items {
+		b, err := s.Marshal(val)
+		if err != nil {
+			return err
+		}
+
+		set := s.store.B().Set().Key(s.key(key)).Value(rueidis.BinaryString(b))
+		if ttl > 0 {
+			cmds = append(cmds, set.PxMilliseconds(ttl.Milliseconds()).Build())
+		} else {
+			cmds = append(cmds, set.Build())
+		}
+	}
+
+	// pipelined
+	for _, res := range s.store.DoMulti(ctx, cmds...) {
+		if err := res.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Rueidis) DelMany(ctx context.Context, keys ...string) error {
+	return s.del(ctx, s.keys(keys))
+}
+
+// del deletes the (redis) keys
+func (s *Rueidis) del(ctx context.Context, keys []string) error {
+	// DELs grouped by slot
+	for _, err := range rueidis.MDel(s.store, ctx, keys) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear deletes the keys of the namespace of the cache (WithNamespace). The redis database being
+// shared (eg: by locks, rate limits), a cache without namespace can't be cleared: ErrNoNamespace.
 func (s *Rueidis) Clear(ctx context.Context) error {
-	return s.client.FlushAll(ctx).Err()
+	if s.namespace == "" {
+		return ErrNoNamespace
+	}
+	return s.DelPrefix(ctx, "")
 }
 
 func (s *Rueidis) Close() error {
@@ -81,18 +358,43 @@ func (s *Rueidis) Close() error {
 	return nil
 }
 
-func (s *Rueidis) Marshal(v any) ([]byte, error) {
-	if Marshal != nil {
-		return Marshal(v)
+// the locks aren't namespaced, never deleted by Clear
+
+// unlockScript deletes the lock only if it is still held with the token (not expired & re-acquired)
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// extendLockScript renews the ttl (ARGV[2] milliseconds) of the lock only if it is still held with the token
+const extendLockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+func (s *Rueidis) tryLock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	token := utils.RandomID(20)
+	if locked, err := s.acquireLock(ctx, key, token, ttl); err != nil || !locked {
+		return nil, err
 	}
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(v)
-	return buf.Bytes(), err
+
+	return func() {
+		_, _ = s.releaseLock(context.Background(), key, token)
+	}, nil
+}
+
+func (s *Rueidis) acquireLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, token, ttl).Result()
+}
+
+func (s *Rueidis) extendLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	n, err := s.client.Eval(ctx, extendLockScript, []string{key}, token, ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+func (s *Rueidis) releaseLock(ctx context.Context, key, token string) (bool, error) {
+	n, err := s.client.Eval(ctx, unlockScript, []string{key}, token).Int64()
+	return n == 1, err
+}
+
+func (s *Rueidis) Marshal(v any) ([]byte, error) {
+	return s.codec.marshal(v)
 }
 
 func (s *Rueidis) Unmarshal(data []byte, vPtr any) error {
-	if Unmarshal != nil {
-		return Unmarshal(data, vPtr)
-	}
-	return gob.NewDecoder(bytes.NewReader(data)).Decode(vPtr)
+	return s.codec.unmarshal(data, vPtr)
 }
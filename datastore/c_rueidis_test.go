@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/rueidis"
 	"github.com/stretchr/testify/assert"
 )
@@ -14,7 +15,8 @@ func TestNewRueidis(t *testing.T) {
 		D string
 	}
 	ctx := context.Background()
-	cache := NewRueidis([]string{"127.0.0.1:6379"}, "", false)
+	redis := miniredis.RunT(t)
+	cache := NewRueidis([]string{redis.Addr()}, "", true)
 
 	// set
 	_ = cache.Set(ctx, "key3", OK{D: "tracy"}, 5*time.Second)
@@ -38,8 +40,10 @@ func TestNewRueidis(t *testing.T) {
 		assert.Equal(t, rueidis.Nil, err, "should return an error")
 	}
 
-	// getting item after expiry
-	time.Sleep(9 * time.Second)
+	_ = cache.Set(ctx, "forever", OK{D: "tracy"}, 0)
+
+	// getting item after expiry (miniredis only expires keys on fast-forwarding)
+	redis.FastForward(9 * time.Second)
 	{
 		expects2 := OK{}
 		var actual2 OK
@@ -47,4 +51,12 @@ func TestNewRueidis(t *testing.T) {
 		assert.Equalf(t, expects2, actual2, "expected nil but got %#v", actual2)
 		assert.Equalf(t, rueidis.Nil, err, "expected nil but got %s", err)
 	}
+
+	// a ttl of 0 never expires
+	{
+		var actual3 OK
+		err := cache.Get(ctx, "forever", &actual3)
+		assert.Equalf(t, nil, err, "expected nil but got %s", err)
+		assert.Equalf(t, expected, actual3, "expected %+v but got: %+v", expected, actual3)
+	}
 }
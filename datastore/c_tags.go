@@ -0,0 +1,37 @@
+package datastore
+
+import "strings"
+
+// SetOption customises the keys set by ICache.Set
+type SetOption func(opts *setOptions)
+
+type setOptions struct {
+	tags []string
+}
+
+func newSetOptions(opts []SetOption) setOptions {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithTags tags the key, to delete it with every other key of a tag at once (ICache.InvalidateTag):
+//
+//	cache.Set(ctx, "profile:42", profile, time.Hour, WithTags("user:42"))
+//	cache.Set(ctx, "orders:42", orders, time.Hour, WithTags("user:42", "orders"))
+//	cache.InvalidateTag(ctx, "user:42") // everything about user 42
+func WithTags(tags ...string) SetOption {
+	return func(opts *setOptions) {
+		opts.tags = append(opts.tags, tags...)
+	}
+}
+
+// tagKey is the key of the (redis) set of the keys tagged with tag
+func tagKey(tag string) string {
+	return "datastore:tag:" + tag
+}
+
+// globEscaper escapes the special characters of the redis glob-style patterns (SCAN MATCH)
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
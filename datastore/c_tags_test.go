@@ -0,0 +1,102 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCacheTags(t *testing.T, cache ICache) {
+	ctx := context.Background()
+
+	_ = cache.Set(ctx, "user:42:profile", "profile", 5*time.Second, WithTags("user:42"))
+	_ = cache.Set(ctx, "user:42:orders", "orders", 5*time.Second, WithTags("user:42", "orders"))
+	_ = cache.Set(ctx, "user:43:orders", "orders", 5*time.Second, WithTags("user:43", "orders"))
+	_ = cache.Set(ctx, "user:4*:stats", "stats", 5*time.Second)
+
+	// by tag
+	{
+		err := cache.InvalidateTag(ctx, "user:42")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "user:42:profile"))
+		assert.False(t, cache.Has(ctx, "user:42:orders"))
+		assert.True(t, cache.Has(ctx, "user:43:orders"))
+
+		err = cache.InvalidateTag(ctx, "orders", "unknown")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "user:43:orders"))
+	}
+
+	// by prefix, special characters included
+	{
+		_ = cache.Set(ctx, "user:43:orders", "orders", 5*time.Second)
+
+		err := cache.DelPrefix(ctx, "user:4*")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "user:4*:stats"))
+		assert.True(t, cache.Has(ctx, "user:43:orders"))
+
+		err = cache.DelPrefix(ctx, "user:")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "user:43:orders"))
+	}
+
+	// clear
+	{
+		_ = cache.Set(ctx, "key", "value", 5*time.Second, WithTags("tag"))
+
+		err := cache.Clear(ctx)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, cache.Has(ctx, "key"))
+	}
+}
+
+func TestCache_Tags(t *testing.T) {
+	redis := miniredis.RunT(t)
+
+	t.Run("GoCache", func(t *testing.T) {
+		cache := NewGoCache()
+		testCacheTags(t, cache)
+		assert.Empty(t, cache.tags)
+		assert.Empty(t, cache.keyTags)
+	})
+	t.Run("Rueidis", func(t *testing.T) {
+		ctx := context.Background()
+		testCacheTags(t, NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:"))
+
+		// tag sets expire with their keys
+		cache := NewRueidis([]string{redis.Addr()}, "", true)
+		_ = cache.Set(ctx, "short", "value", time.Second, WithTags("expiring"))
+		_ = cache.Set(ctx, "long", "value", time.Minute, WithTags("expiring"))
+		assert.Equal(t, time.Minute, redis.TTL(tagKey("expiring")))
+
+		// invalidated tag sets are deleted with their keys
+		err := cache.InvalidateTag(ctx, "expiring")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, redis.Exists(tagKey("expiring")))
+		assert.False(t, redis.Exists("long"))
+
+		// clearing a namespace keeps the other keys of the database
+		_ = redis.Set("datastore:lock:payment", "token")
+		namespaced := cache.WithNamespace("cache:")
+		_ = namespaced.Set(ctx, "key", "value", time.Minute, WithTags("tag"))
+		assert.True(t, redis.Exists("cache:key"))
+		assert.True(t, redis.Exists("cache:"+tagKey("tag")))
+
+		err = namespaced.Clear(ctx)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, []string{"datastore:lock:payment"}, redis.Keys())
+
+		err = cache.Clear(ctx)
+		assert.Equalf(t, ErrNoNamespace, err, "expected %+v but got: %+v", ErrNoNamespace, err)
+		assert.True(t, redis.Exists("datastore:lock:payment"))
+	})
+	t.Run("TieredCache", func(t *testing.T) {
+		cache := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:"), time.Minute)
+		defer cache.Close()
+		testCacheTags(t, cache)
+	})
+}
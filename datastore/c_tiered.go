@@ -0,0 +1,320 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otyang/go-pkg/utils"
+	"github.com/redis/rueidis"
+)
+
+var _ ICache = (*TieredCache)(nil)
+
+// tieredChannel is the redis channel on which instances broadcast the keys they change, in the
+// namespace of L2: the caches of other namespaces don't get them
+const tieredChannel = "datastore:cache:invalidations"
+
+// TieredCache is an ICache layering an in-process GoCache (L1) over a redis Rueidis (L2) shared by the
+// instances, so that hot reads skip the network.
+//
+// Reads are served by L1, or by L2 populating L1 for the (shorter) l1TTL. Writes go through to both,
+// & are broadcast on a redis channel (pub/sub) for the other instances to drop the key from their L1.
+// An L1 may serve a changed key for as long as the broadcast takes (or for l1TTL, if the subscription
+// is broken): pick l1TTL accordingly. L1 is flushed when the subscription is re-established.
+//
+// A read populating L1 from L2 is dropped if L1 was invalidated meanwhile, so that a value read from
+// L2 before a write never overwrites the write in L1.
+type TieredCache struct {
+	l1    *GoCache
+	l2    *Rueidis
+	l1TTL time.Duration
+
+	mu            sync.Mutex
+	invalidations uint64 // the changes of L1 by writes & broadcasts, guarded by mu
+
+	instance string
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewTieredCache returns a cache of l1 over l2, & subscribes to the invalidations of the other
+// instances until Close.
+func NewTieredCache(l1 *GoCache, l2 *Rueidis, l1TTL time.Duration) *TieredCache {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &TieredCache{
+		l1:       l1,
+		l2:       l2,
+		l1TTL:    l1TTL,
+		instance: utils.RandomID(20),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go c.subscribe(ctx)
+	return c
+}
+
+func (c *TieredCache) Has(ctx context.Context, key string) bool {
+	return c.l1.Has(ctx, key) || c.l2.Has(ctx, key)
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string, dest any) error {
+	if err := c.l1.Get(ctx, key, dest); err == nil {
+		return nil
+	}
+
+	invalidations := c.invalidationCount()
+	if err := c.l2.Get(ctx, key, dest); err != nil {
+		return err
+	}
+	c.fill(invalidations, func() {
+		_ = c.l1.Set(ctx, key, dest, c.l1TTL)
+	})
+	return nil
+}
+
+func (c *TieredCache) Set(ctx context.Context, key string, val any, ttl time.Duration, opts ...SetOption) error {
+	if err := c.l2.Set(ctx, key, val, ttl, opts...); err != nil {
+		return err
+	}
+	c.publish(ctx, key)
+	return c.invalidate(func() error {
+		return c.l1.Set(ctx, key, val, c.ttl(ttl), opts...)
+	})
+}
+
+func (c *TieredCache) Del(ctx context.Context, key string) error {
+	err := c.l2.Del(ctx, key)
+	_ = c.invalidate(func() error { return c.l1.Del(ctx, key) })
+	if err != nil {
+		return err
+	}
+	c.publish(ctx, key)
+	return nil
+}
+
+func (c *TieredCache) SetIfAbsent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	set, err := c.l2.SetIfAbsent(ctx, key, val, ttl)
+	return c.setIf(ctx, key, val, ttl, set, err)
+}
+
+func (c *TieredCache) SetIfPresent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error) {
+	set, err := c.l2.SetIfPresent(ctx, key, val, ttl)
+	return c.setIf(ctx, key, val, ttl, set, err)
+}
+
+func (c *TieredCache) CompareAndSwap(ctx context.Context, key string, oldVal, newVal any, ttl time.Duration) (bool, error) {
+	set, err := c.l2.CompareAndSwap(ctx, key, oldVal, newVal, ttl)
+	return c.setIf(ctx, key, newVal, ttl, set, err)
+}
+
+// setIf completes a conditional write, decided by L2
+func (c *TieredCache) setIf(ctx context.Context, key string, val any, ttl time.Duration, set bool, err error) (bool, error) {
+	if err != nil || !set {
+		return false, err
+	}
+	c.publish(ctx, key)
+	return true, c.invalidate(func() error {
+		return c.l1.Set(ctx, key, val, c.ttl(ttl))
+	})
+}
+
+func (c *TieredCache) GetAndDelete(ctx context.Context, key string, dest any) error {
+	err := c.l2.GetAndDelete(ctx, key, dest)
+	_ = c.invalidate(func() error { return c.l1.Del(ctx, key) })
+	if err != nil {
+		return err
+	}
+	c.publish(ctx, key)
+	return nil
+}
+
+// Incr increments the counter of key in L2, shared by the instances. See IncrBy.
+func (c *TieredCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.l2.Incr(ctx, key, ttl)
+}
+
+// IncrBy increments the counter of key in L2, shared by the instances: counters aren't cached in L1.
+func (c *TieredCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.l2.IncrBy(ctx, key, delta, ttl)
+}
+
+// Decr decrements the counter of key in L2, shared by the instances. See IncrBy.
+func (c *TieredCache) Decr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.l2.Decr(ctx, key, ttl)
+}
+
+// InvalidateTag deletes the keys of tags, as listed by L2 (the keys read from L2 having no tags in L1):
+// they are broadcast to the other instances.
+func (c *TieredCache) InvalidateTag(ctx context.Context, tags ...string) error {
+	keys, err := c.l2.invalidateTag(ctx, tags)
+	_ = c.invalidate(func() error {
+		return errors.Join(c.l1.DelMany(ctx, keys...), c.l1.InvalidateTag(ctx, tags...))
+	})
+	for _, key := range keys {
+		c.publish(ctx, key)
+	}
+	return err
+}
+
+// DelPrefix deletes the keys starting with prefix. The other instances flush their L1.
+func (c *TieredCache) DelPrefix(ctx context.Context, prefix string) error {
+	err := c.l2.DelPrefix(ctx, prefix)
+	_ = c.invalidate(func() error { return c.l1.DelPrefix(ctx, prefix) })
+	if err != nil {
+		return err
+	}
+	c.publish(ctx, "")
+	return nil
+}
+
+func (c *TieredCache) GetMany(ctx context.Context, keys []string, destMapPtr any) ([]string, error) {
+	l1Missing, err := c.l1.GetMany(ctx, keys, destMapPtr)
+	if err != nil || len(l1Missing) == 0 {
+		return l1Missing, err
+	}
+
+	invalidations := c.invalidationCount()
+	missing, err := c.l2.GetMany(ctx, l1Missing, destMapPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	// populate L1 with the keys found in L2
+	dest := reflect.ValueOf(destMapPtr).Elem()
+	found := make(map[string]any, len(l1Missing)-len(missing))
+	for _, key := range l1Missing {
+		if v := dest.MapIndex(reflect.ValueOf(key).Convert(dest.Type().Key())); v.IsValid() {
+			found[key] = v.Interface()
+		}
+	}
+	c.fill(invalidations, func() {
+		_ = c.l1.SetMany(ctx, found, c.l1TTL)
+	})
+
+	return missing, nil
+}
+
+func (c *TieredCache) SetMany(ctx context.Context, items map[string]any, ttl time.Duration) error {
+	if err := c.l2.SetMany(ctx, items, ttl); err != nil {
+		return err
+	}
+	for key := range items {
+		c.publish(ctx, key)
+	}
+	return c.invalidate(func() error {
+		return c.l1.SetMany(ctx, items, c.ttl(ttl))
+	})
+}
+
+func (c *TieredCache) DelMany(ctx context.Context, keys ...string) error {
+	err := c.l2.DelMany(ctx, keys...)
+	_ = c.invalidate(func() error { return c.l1.DelMany(ctx, keys...) })
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		c.publish(ctx, key)
+	}
+	return nil
+}
+
+func (c *TieredCache) Clear(ctx context.Context) error {
+	err := c.l2.Clear(ctx)
+	_ = c.invalidate(func() error { return c.l1.Clear(ctx) })
+	if err != nil {
+		return err
+	}
+	c.publish(ctx, "")
+	return nil
+}
+
+// Close stops the subscription & closes both caches
+func (c *TieredCache) Close() error {
+	c.cancel()
+	<-c.done
+	return errors.Join(c.l1.Close(), c.l2.Close())
+}
+
+// ttl is the L1 ttl of a value cached for ttl in L2
+func (c *TieredCache) ttl(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < c.l1TTL {
+		return ttl
+	}
+	return c.l1TTL
+}
+
+// invalidationCount is the count of the changes of L1 so far, to read before reading L2 for fill
+func (c *TieredCache) invalidationCount() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.invalidations
+}
+
+// fill populates L1 with the values read from L2, unless L1 was changed since invalidations (the
+// count read before reading L2): the values may be older than the change.
+func (c *TieredCache) fill(invalidations uint64, set func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidations == invalidations {
+		set()
+	}
+}
+
+// invalidate changes L1 with change, once L2 is changed: the fills of values read from L2 before are
+// dropped, or overwritten by change.
+func (c *TieredCache) invalidate(change func() error) error {
+	c.mu.Lock()
+	c.invalidations++
+	c.mu.Unlock()
+
+	return change()
+}
+
+// channel is the redis channel of the invalidations, in the namespace of L2
+func (c *TieredCache) channel() string {
+	return c.l2.key(tieredChannel)
+}
+
+// publish broadcasts the change of key ("" for all keys) to the other instances
+func (c *TieredCache) publish(ctx context.Context, key string) {
+	cmd := c.l2.store.B().Publish().Channel(c.channel()).Message(c.instance + "|" + key).Build()
+	_ = c.l2.store.Do(ctx, cmd).Error()
+}
+
+// subscribe drops from L1 the keys changed by the other instances, resubscribing until ctx is done
+func (c *TieredCache) subscribe(ctx context.Context) {
+	defer close(c.done)
+
+	for resubscribing := false; ctx.Err() == nil; resubscribing = true {
+		if resubscribing {
+			// the broadcasts were missed while disconnected
+			_ = c.invalidate(func() error { return c.l1.Clear(ctx) })
+		}
+
+		// on a dedicated connection: a subscribed connection can't serve the other commands on every server
+		_ = c.l2.store.Dedicated(func(client rueidis.DedicatedClient) error {
+			cmd := client.B().Subscribe().Channel(c.channel()).Build()
+			return client.Receive(ctx, cmd, func(msg rueidis.PubSubMessage) {
+				instance, key, _ := strings.Cut(msg.Message, "|")
+				switch {
+				case instance == c.instance:
+				case key == "":
+					_ = c.invalidate(func() error { return c.l1.Clear(ctx) })
+				default:
+					_ = c.invalidate(func() error { return c.l1.Del(ctx, key) })
+				}
+			})
+		})
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
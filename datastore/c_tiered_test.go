@@ -0,0 +1,152 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredCache(t *testing.T) {
+	ctx := context.Background()
+	redis := miniredis.RunT(t)
+
+	// two instances
+	a := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:"), time.Minute)
+	b := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:"), time.Minute)
+	assert.Eventually(t, func() bool {
+		return redis.PubSubNumSub(a.channel())[a.channel()] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// another namespace: not invalidated by the instances of "cache:"
+	other := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("other:"), time.Minute)
+	assert.Eventually(t, func() bool {
+		return redis.PubSubNumSub(other.channel())[other.channel()] == 1
+	}, time.Second, 10*time.Millisecond)
+	err := other.Set(ctx, "key", "other", time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// reads populate L1
+	{
+		err := a.Set(ctx, "key", "v1", time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		var actual string
+		err = b.Get(ctx, "key", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v1", actual)
+
+		// not filled while the broadcast of the write may be pending
+		assert.Eventually(t, func() bool {
+			_ = b.Get(ctx, "key", &actual)
+			return b.l1.Has(ctx, "key")
+		}, time.Second, 10*time.Millisecond)
+
+		// served by L1, without redis
+		redis.Del("cache:key")
+		actual = ""
+		err = b.Get(ctx, "key", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v1", actual)
+	}
+
+	// changes invalidate the L1 of the other instances
+	{
+		err := a.Del(ctx, "key")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Eventually(t, func() bool { return !b.l1.Has(ctx, "key") }, time.Second, 10*time.Millisecond)
+
+		var actual string
+		err = b.Get(ctx, "key", &actual)
+		assert.Truef(t, IsErrNotFound(err), "expected not found but got: %+v", err)
+
+		err = a.Set(ctx, "key", "v2", time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		err = b.Get(ctx, "key", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v2", actual)
+
+		err = a.Clear(ctx)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Eventually(t, func() bool { return !b.l1.Has(ctx, "key") }, time.Second, 10*time.Millisecond)
+		assert.False(t, a.Has(ctx, "key"))
+	}
+
+	// tags invalidate their keys only, in the L1 of every instance
+	{
+		err := a.Set(ctx, "tagged", "v1", time.Minute, WithTags("users"))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		err = a.Set(ctx, "untagged", "v1", time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		var actual string
+		assert.Eventually(t, func() bool {
+			_ = b.Get(ctx, "tagged", &actual)
+			_ = b.Get(ctx, "untagged", &actual)
+			return b.l1.Has(ctx, "tagged") && b.l1.Has(ctx, "untagged")
+		}, time.Second, 10*time.Millisecond)
+
+		err = a.InvalidateTag(ctx, "users")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.False(t, a.l1.Has(ctx, "tagged"))
+		assert.True(t, a.l1.Has(ctx, "untagged"))
+		assert.Eventually(t, func() bool { return !b.l1.Has(ctx, "tagged") }, time.Second, 10*time.Millisecond)
+		assert.True(t, b.l1.Has(ctx, "untagged"))
+		assert.False(t, b.Has(ctx, "tagged"))
+	}
+
+	// the namespaces don't share invalidations
+	assert.True(t, other.l1.Has(ctx, "key"))
+
+	assert.Nil(t, other.Close())
+	assert.Nil(t, a.Close())
+	assert.Nil(t, b.Close())
+}
+
+func TestTieredCache_FillRace(t *testing.T) {
+	ctx := context.Background()
+	redis := miniredis.RunT(t)
+	cache := NewTieredCache(NewGoCache(), NewRueidis([]string{redis.Addr()}, "", true).WithNamespace("cache:"), time.Minute)
+	defer cache.Close()
+
+	// a value read from L2 before a write isn't filled in L1
+	{
+		_ = cache.l2.Set(ctx, "key", "v1", time.Minute)
+
+		invalidations := cache.invalidationCount()
+		var stale string
+		_ = cache.l2.Get(ctx, "key", &stale)
+
+		err := cache.Set(ctx, "key", "v2", time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		cache.fill(invalidations, func() { _ = cache.l1.Set(ctx, "key", stale, time.Minute) })
+
+		var actual string
+		err = cache.l1.Get(ctx, "key", &actual)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v2", actual)
+	}
+
+	// reads racing writes: L1 never holds an older value than L2
+	for i := 0; i < 200; i++ {
+		_ = cache.l1.Del(ctx, "race")
+		_ = cache.l2.Set(ctx, "race", "old", time.Minute)
+
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+			var v string
+			_ = cache.Get(ctx, "race", &v)
+		}()
+		err := cache.Set(ctx, "race", "new", time.Minute)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		<-read
+
+		var actual string
+		if err := cache.l1.Get(ctx, "race", &actual); err == nil && actual != "new" {
+			t.Fatalf("expected L1 to hold %q but got: %q", "new", actual)
+		}
+	}
+}
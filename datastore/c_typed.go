@@ -0,0 +1,310 @@
+package datastore
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// TypedCache is an ICache storing values of type T, sparing the call sites the destination & type
+// assertion of ICache.Get:
+//
+//	users := NewTypedCache[User](cache, "")
+//	user, err := users.GetOrLoad(ctx, id, time.Minute, func(ctx context.Context) (User, error) {
+//		return loadUser(ctx, id)
+//	})
+//
+// Keys are prefixed per type ('<prefix>:<key>'), so that caches of different types sharing an ICache
+// never read each other's values.
+//
+// GetOrLoad protects the loader from stampedes when a hot key expires: concurrent loads of a key are
+// coalesced into ONE call of the loader, & see WithStaleWhileRevalidate, WithEarlyExpiration &
+// WithLoadLock to also spare the waiting & the loads of other instances.
+type TypedCache[T any] struct {
+	cache  ICache
+	prefix string
+	loads  *singleflight.Group
+
+	stale       time.Duration
+	beta        float64
+	lockTTL     time.Duration
+	loadTimeout time.Duration
+}
+
+// typedEntry is the cache entry of a value: with when it expires (unix nanoseconds, 0 never), & how
+// long it took to load for the early expiration.
+type typedEntry[T any] struct {
+	Value      T
+	FreshUntil int64
+	LoadTime   time.Duration
+}
+
+// loadLocker is implemented by the caches shared by instances (eg: Rueidis), to coalesce the loads
+// of a key across instances
+type loadLocker interface {
+	// tryLock acquires the lock of key for ttl without waiting. release is nil when not acquired.
+	tryLock(ctx context.Context, key string, ttl time.Duration) (release func(), err error)
+}
+
+// NewTypedCache wraps cache for values of type T. Keys are prefixed with prefix, or with the name of
+// T (eg: 'models.User') when empty.
+func NewTypedCache[T any](cache ICache, prefix string) *TypedCache[T] {
+	if prefix == "" {
+		prefix = reflect.TypeOf((*T)(nil)).Elem().String()
+	}
+	return &TypedCache[T]{cache: cache, prefix: prefix, loads: &singleflight.Group{}, loadTimeout: 30 * time.Second}
+}
+
+// WithStaleWhileRevalidate returns a copy of the cache keeping values stale for after their ttl:
+// GetOrLoad serves a stale value at once, while refreshing it in the background.
+func (c *TypedCache[T]) WithStaleWhileRevalidate(stale time.Duration) *TypedCache[T] {
+	clone := *c
+	clone.stale = stale
+	return &clone
+}
+
+// WithEarlyExpiration returns a copy of the cache refreshing values in the background before their
+// ttl, with a probability rising as the expiry nears & for values slow to load (probabilistic early
+// expiration, aka XFetch), so that hot keys are rarely seen expired. beta scales it: 1 is a good
+// default, > 1 refreshes earlier & 0 disables it.
+func (c *TypedCache[T]) WithEarlyExpiration(beta float64) *TypedCache[T] {
+	clone := *c
+	clone.beta = beta
+	return &clone
+}
+
+// WithLoadLock returns a copy of the cache coalescing the loads of a key across instances, when the
+// cache is shared (Rueidis): ONE instance loads while holding a lock for up to lockTTL, & the others
+// wait for its value, loading themselves if none comes before lockTTL. Caches local to the instance
+// (GoCache) ignore it.
+func (c *TypedCache[T]) WithLoadLock(lockTTL time.Duration) *TypedCache[T] {
+	clone := *c
+	clone.lockTTL = lockTTL
+	return &clone
+}
+
+// WithLoadTimeout returns a copy of the cache giving up the loads of GetOrLoad after timeout (30s by
+// default). Loads aren't cancelled by the callers waiting for them, being shared.
+func (c *TypedCache[T]) WithLoadTimeout(timeout time.Duration) *TypedCache[T] {
+	clone := *c
+	clone.loadTimeout = timeout
+	return &clone
+}
+
+// Get gets the value of key, stale or not. A missing key returns the error of the cache (see IsErrNotFound).
+func (c *TypedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	entry, err := c.get(ctx, c.key(key))
+	return entry.Value, err
+}
+
+// Set sets the value of key for ttl, eg: tagged with WithTags
+func (c *TypedCache[T]) Set(ctx context.Context, key string, v T, ttl time.Duration, opts ...SetOption) error {
+	return c.cache.Set(ctx, c.key(key), c.entry(v, ttl, 0), c.cacheTTL(ttl), opts...)
+}
+
+// Has checks to see if key exists in the cache
+func (c *TypedCache[T]) Has(ctx context.Context, key string) bool {
+	return c.cache.Has(ctx, c.key(key))
+}
+
+// Del deletes key from the cache
+func (c *TypedCache[T]) Del(ctx context.Context, key string) error {
+	return c.cache.Del(ctx, c.key(key))
+}
+
+// GetMany gets the values of keys, stale or not, in as few round trips as possible. It returns the
+// missing keys, eg: to load from the database.
+func (c *TypedCache[T]) GetMany(ctx context.Context, keys []string) (map[string]T, []string, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.key(key)
+	}
+
+	var entries map[string]typedEntry[T]
+	missing, err := c.cache.GetMany(ctx, prefixed, &entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]T, len(entries))
+	for key, entry := range entries {
+		values[strings.TrimPrefix(key, c.prefix+":")] = entry.Value
+	}
+	for i, key := range missing {
+		missing[i] = strings.TrimPrefix(key, c.prefix+":")
+	}
+	return values, missing, nil
+}
+
+// SetMany sets the values of items for ttl
+func (c *TypedCache[T]) SetMany(ctx context.Context, items map[string]T, ttl time.Duration) error {
+	entries := make(map[string]any, len(items))
+	for key, v := range items {
+		entries[c.key(key)] = c.entry(v, ttl, 0)
+	}
+	return c.cache.SetMany(ctx, entries, c.cacheTTL(ttl))
+}
+
+// DelMany deletes keys from the cache
+func (c *TypedCache[T]) DelMany(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.key(key)
+	}
+	return c.cache.DelMany(ctx, prefixed...)
+}
+
+// GetOrLoad gets the value of key or, when it can't be read from the cache, loads it with loader &
+// caches it for ttl (0: never expires). Errors of loader are returned (& not cached); failing to cache
+// the loaded value isn't an error.
+//
+// A load is shared by the callers of the key: loader gets a context with the values of ctx, but not
+// its cancellation (see WithLoadTimeout). A caller whose ctx is done stops waiting with ctx.Err(),
+// the others still get the value.
+func (c *TypedCache[T]) GetOrLoad(
+	ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error),
+) (T, error) {
+	key = c.key(key)
+
+	if entry, err := c.get(ctx, key); err == nil {
+		if c.expired(entry) {
+			// stale, or expiring early: refresh in the background
+			c.loads.DoChan(key, func() (any, error) {
+				return c.detachedLoad(ctx, key, ttl, loader)
+			})
+		}
+		return entry.Value, nil
+	}
+
+	loaded := c.loads.DoChan(key, func() (any, error) {
+		return c.detachedLoad(ctx, key, ttl, loader)
+	})
+
+	var zero T
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case res := <-loaded:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	}
+}
+
+// detachedLoad loads the value of key without the cancellation of ctx, within the load timeout
+func (c *TypedCache[T]) detachedLoad(
+	ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error),
+) (T, error) {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, c.loadTimeout)
+	defer cancel()
+	return c.load(ctx, key, ttl, loader)
+}
+
+// load loads the value of key & caches it. Other instances wait for it when locking.
+func (c *TypedCache[T]) load(
+	ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error),
+) (T, error) {
+	if locker, ok := c.cache.(loadLocker); ok && c.lockTTL > 0 {
+		release, err := locker.tryLock(ctx, key+":lock", c.lockTTL)
+		switch {
+		case err == nil && release != nil:
+			defer release()
+		case err == nil:
+			if entry, err := c.wait(ctx, key); err == nil {
+				return entry.Value, nil
+			}
+		}
+		// the lock or the cache is unavailable, or the other instance is late: load
+	}
+
+	start := time.Now()
+	v, err := loader(ctx)
+	if err != nil {
+		return v, err
+	}
+
+	_ = c.set(ctx, key, v, ttl, time.Since(start))
+	return v, nil
+}
+
+// wait waits up to the lock ttl for the fresh value loaded by another instance
+func (c *TypedCache[T]) wait(ctx context.Context, key string) (typedEntry[T], error) {
+	ctx, cancel := context.WithTimeout(ctx, c.lockTTL)
+	defer cancel()
+
+	ticker := time.NewTicker(c.lockTTL/20 + time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return typedEntry[T]{}, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if entry, err := c.get(ctx, key); err == nil && entry.fresh(time.Now().UnixNano()) {
+			return entry, nil
+		}
+	}
+}
+
+// expired reports whether the value of entry must be refreshed: stale, or expiring early
+func (c *TypedCache[T]) expired(entry typedEntry[T]) bool {
+	now := time.Now().UnixNano()
+	if c.beta > 0 && entry.LoadTime > 0 {
+		now -= int64(float64(entry.LoadTime) * c.beta * math.Log(rand.Float64()))
+	}
+	return !entry.fresh(now)
+}
+
+// fresh reports whether the entry is fresh at now (unix nanoseconds)
+func (e typedEntry[T]) fresh(now int64) bool {
+	return e.FreshUntil == 0 || now < e.FreshUntil
+}
+
+func (c *TypedCache[T]) get(ctx context.Context, key string) (typedEntry[T], error) {
+	var entry typedEntry[T]
+	err := c.cache.Get(ctx, key, &entry)
+	return entry, err
+}
+
+func (c *TypedCache[T]) set(ctx context.Context, key string, v T, ttl, loadTime time.Duration) error {
+	return c.cache.Set(ctx, key, c.entry(v, ttl, loadTime), c.cacheTTL(ttl))
+}
+
+// entry is the entry of v, never stale for a ttl of 0
+func (c *TypedCache[T]) entry(v T, ttl, loadTime time.Duration) typedEntry[T] {
+	entry := typedEntry[T]{Value: v, LoadTime: loadTime}
+	if ttl > 0 {
+		entry.FreshUntil = time.Now().Add(ttl).UnixNano()
+	}
+	return entry
+}
+
+// cacheTTL is the ttl of the entries fresh for ttl: kept stale for a while, or never expiring for 0
+func (c *TypedCache[T]) cacheTTL(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return 0
+	}
+	return ttl + c.stale
+}
+
+func (c *TypedCache[T]) key(key string) string {
+	return c.prefix + ":" + key
+}
+
+// detachedContext carries the values of its parent, but not its deadline & cancellation (as
+// context.WithoutCancel of go 1.21)
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
@@ -0,0 +1,254 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedCache(t *testing.T) {
+	type Profile struct {
+		Name string
+	}
+	ctx := context.Background()
+	cache := NewGoCache()
+
+	profiles := NewTypedCache[Profile](cache, "")
+	counts := NewTypedCache[int](cache, "counts")
+
+	// get & set
+	{
+		err := profiles.Set(ctx, "1", Profile{Name: "tracy"}, 5*time.Second)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		actual, err := profiles.Get(ctx, "1")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, Profile{Name: "tracy"}, actual)
+		assert.True(t, cache.Has(ctx, "datastore.Profile:1"))
+	}
+
+	// keys are prefixed per type
+	{
+		_, err := counts.Get(ctx, "1")
+		assert.Truef(t, IsErrNotFound(err), "expected not found but got: %+v", err)
+	}
+
+	// get or load
+	{
+		loads := 0
+		loader := func(ctx context.Context) (int, error) {
+			loads++
+			return 42, nil
+		}
+
+		for i := 0; i < 2; i++ {
+			actual, err := counts.GetOrLoad(ctx, "answer", 5*time.Second, loader)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+			assert.Equal(t, 42, actual)
+		}
+		assert.Equal(t, 1, loads)
+
+		errLoad := errors.New("load failed")
+		_, err := counts.GetOrLoad(ctx, "failing", 5*time.Second, func(ctx context.Context) (int, error) {
+			return 0, errLoad
+		})
+		assert.Equal(t, errLoad, err)
+		assert.False(t, counts.Has(ctx, "failing"))
+	}
+
+	// del
+	{
+		err := profiles.Del(ctx, "1")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		_, err = profiles.Get(ctx, "1")
+		assert.Equal(t, ErrNotFoundGoCache, err)
+	}
+}
+
+// lockedCache is a shared cache whose load locks are held by another instance
+type lockedCache struct {
+	*GoCache
+	locked bool
+}
+
+func (c *lockedCache) tryLock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	if c.locked {
+		return nil, nil
+	}
+	return func() {}, nil
+}
+
+func TestTypedCache_GetOrLoad(t *testing.T) {
+	ctx := context.Background()
+
+	var loads int32
+	loader := func(v string, delay time.Duration) func(ctx context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(delay)
+			return v, nil
+		}
+	}
+
+	// concurrent loads are coalesced
+	{
+		loads = 0
+		cache := NewTypedCache[string](NewGoCache(), "")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				actual, err := cache.GetOrLoad(ctx, "hot", time.Minute, loader("v1", 50*time.Millisecond))
+				assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+				assert.Equal(t, "v1", actual)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	}
+
+	// stale values are served while refreshing
+	{
+		loads = 0
+		cache := NewTypedCache[string](NewGoCache(), "").WithStaleWhileRevalidate(time.Minute)
+
+		_, _ = cache.GetOrLoad(ctx, "stale", 20*time.Millisecond, loader("v1", 0))
+		time.Sleep(30 * time.Millisecond)
+
+		actual, err := cache.GetOrLoad(ctx, "stale", time.Minute, loader("v2", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v1", actual)
+
+		assert.Eventually(t, func() bool {
+			actual, _ := cache.Get(ctx, "stale")
+			return actual == "v2"
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	}
+
+	// values slow to load expire early
+	{
+		loads = 0
+		cache := NewTypedCache[string](NewGoCache(), "").WithEarlyExpiration(1e6)
+
+		_, _ = cache.GetOrLoad(ctx, "early", time.Minute, loader("v1", time.Millisecond))
+		actual, err := cache.GetOrLoad(ctx, "early", time.Minute, loader("v2", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "v1", actual)
+
+		assert.Eventually(t, func() bool {
+			actual, _ := cache.Get(ctx, "early")
+			return actual == "v2"
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// another instance holding the load lock: wait for its value
+	{
+		loads = 0
+		shared := &lockedCache{GoCache: NewGoCache(), locked: true}
+		cache := NewTypedCache[string](shared, "").WithLoadLock(time.Second)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = NewTypedCache[string](shared, "").Set(ctx, "locked", "theirs", time.Minute)
+		}()
+
+		actual, err := cache.GetOrLoad(ctx, "locked", time.Minute, loader("ours", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "theirs", actual)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+
+		// the other instance is late: load
+		cache = cache.WithLoadLock(50 * time.Millisecond)
+		actual, err = cache.GetOrLoad(ctx, "late", time.Minute, loader("ours", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "ours", actual)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	}
+
+	// a ttl of 0 never expires: never reloaded, & waited for no longer than its load
+	{
+		loads = 0
+		shared := &lockedCache{GoCache: NewGoCache(), locked: true}
+		cache := NewTypedCache[string](shared, "").WithLoadLock(2 * time.Second).WithStaleWhileRevalidate(time.Millisecond)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = NewTypedCache[string](shared, "").Set(ctx, "forever", "theirs", 0)
+		}()
+
+		start := time.Now()
+		actual, err := cache.GetOrLoad(ctx, "forever", 0, loader("ours", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "theirs", actual)
+		assert.Lessf(t, time.Since(start), time.Second, "expected the value to be waited for until set only")
+
+		time.Sleep(10 * time.Millisecond)
+		actual, err = cache.GetOrLoad(ctx, "forever", 0, loader("ours", 0))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equal(t, "theirs", actual)
+
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+	}
+}
+
+func TestTypedCache_GetOrLoad_Cancelled(t *testing.T) {
+	ctx := context.Background()
+	cache := NewTypedCache[string](NewGoCache(), "")
+
+	type ctxKey struct{}
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		<-release
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return ctx.Value(ctxKey{}).(string), nil
+	}
+
+	// the first caller gives up: the shared load goes on for the others, with its values
+	first, cancel := context.WithCancel(context.WithValue(ctx, ctxKey{}, "v1"))
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrLoad(first, "key", time.Minute, loader)
+		firstErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	type result struct {
+		v   string
+		err error
+	}
+	second := make(chan result, 1)
+	go func() {
+		v, err := cache.GetOrLoad(ctx, "key", time.Minute, loader)
+		second <- result{v, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	err := <-firstErr
+	assert.Equalf(t, context.Canceled, err, "expected %+v but got: %+v", context.Canceled, err)
+
+	close(release)
+	res := <-second
+	assert.Equalf(t, nil, res.err, "expected %+v but got: %+v", nil, res.err)
+	assert.Equal(t, "v1", res.v)
+
+	// loads time out
+	cache = cache.WithLoadTimeout(20 * time.Millisecond)
+	_, err = cache.GetOrLoad(ctx, "slow", time.Minute, func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	assert.Equalf(t, context.DeadlineExceeded, err, "expected %+v but got: %+v", context.DeadlineExceeded, err)
+}
@@ -0,0 +1,385 @@
+// Package cachetest is a conformance suite of datastore.ICache, so that every implementation
+// (GoCache, Rueidis, custom ones) proves it behaves the same.
+//
+//	func TestMyCache(t *testing.T) {
+//		cachetest.Run(t, func(t *testing.T) datastore.ICache {
+//			return NewMyCache()
+//		})
+//	}
+package cachetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns a new cache for a test. Keys are unique per test, so caches may share a store.
+type Factory func(t *testing.T) datastore.ICache
+
+// Option customises the suite
+type Option func(s *suite)
+
+// WithSleep replaces time.Sleep waiting for keys to expire, eg: to also fast-forward the clock of a
+// redis stand-in (miniredis doesn't expire keys by itself).
+func WithSleep(sleep func(d time.Duration)) Option {
+	return func(s *suite) {
+		s.sleep = sleep
+	}
+}
+
+type suite struct {
+	factory Factory
+	sleep   func(d time.Duration)
+}
+
+type value struct {
+	Name  string
+	Count int
+}
+
+// Run runs the conformance suite against the caches of factory
+func Run(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{factory: factory, sleep: time.Sleep}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	t.Run("NotFound", s.testNotFound)
+	t.Run("SetGet", s.testSetGet)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("TTL", s.testTTL)
+	t.Run("TTLZero", s.testTTLZero)
+	t.Run("Del", s.testDel)
+	t.Run("Many", s.testMany)
+	t.Run("ConditionalWrites", s.testConditionalWrites)
+	t.Run("Tags", s.testTags)
+	t.Run("DelPrefix", s.testDelPrefix)
+	t.Run("Counters", s.testCounters)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("Clear", s.testClear)
+	t.Run("Close", s.testClose)
+}
+
+// cache returns a cache of the factory & a key maker unique to the test
+func (s *suite) cache(t *testing.T) (datastore.ICache, func(name string) string) {
+	cache := s.factory(t)
+	return cache, func(name string) string {
+		return t.Name() + ":" + name
+	}
+}
+
+func (s *suite) testNotFound(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	var actual value
+	err := cache.Get(ctx, key("missing"), &actual)
+	assert.Truef(t, datastore.IsErrNotFound(err), "expected not found but got: %+v", err)
+	assert.False(t, cache.Has(ctx, key("missing")))
+
+	err = cache.GetAndDelete(ctx, key("missing"), &actual)
+	assert.Truef(t, datastore.IsErrNotFound(err), "expected not found but got: %+v", err)
+
+	err = cache.Del(ctx, key("missing"))
+	assert.Equalf(t, nil, err, "deleting a missing key: expected %+v but got: %+v", nil, err)
+}
+
+func (s *suite) testSetGet(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	expected := value{Name: "tracy", Count: 42}
+	err := cache.Set(ctx, key("key"), expected, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.True(t, cache.Has(ctx, key("key")))
+
+	var actual value
+	err = cache.Get(ctx, key("key"), &actual)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, expected, actual)
+
+	var s2 string
+	err = cache.Set(ctx, key("string"), "tracy", time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	err = cache.Get(ctx, key("string"), &s2)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "tracy", s2)
+}
+
+func (s *suite) testOverwrite(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("key"), value{Name: "a"}, time.Minute)
+	err := cache.Set(ctx, key("key"), value{Name: "b"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	var actual value
+	err = cache.Get(ctx, key("key"), &actual)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, value{Name: "b"}, actual)
+}
+
+func (s *suite) testTTL(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("short"), value{Name: "short"}, 200*time.Millisecond)
+	_ = cache.Set(ctx, key("long"), value{Name: "long"}, time.Minute)
+	assert.True(t, cache.Has(ctx, key("short")))
+
+	s.sleep(400 * time.Millisecond)
+
+	var actual value
+	err := cache.Get(ctx, key("short"), &actual)
+	assert.Truef(t, datastore.IsErrNotFound(err), "expected not found after expiry but got: %+v", err)
+	assert.False(t, cache.Has(ctx, key("short")))
+	assert.True(t, cache.Has(ctx, key("long")))
+
+	// overwriting sets the new ttl
+	_ = cache.Set(ctx, key("long"), value{Name: "long"}, 200*time.Millisecond)
+	s.sleep(400 * time.Millisecond)
+	assert.False(t, cache.Has(ctx, key("long")))
+}
+
+// testTTLZero checks a ttl of 0 never expires, whatever the write
+func (s *suite) testTTLZero(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("set"), value{Name: "set"}, 0)
+	_, _ = cache.SetIfAbsent(ctx, key("absent"), value{Name: "absent"}, 0)
+	_ = cache.Set(ctx, key("present"), value{Name: "present"}, 200*time.Millisecond)
+	_, _ = cache.SetIfPresent(ctx, key("present"), value{Name: "present"}, 0)
+	_ = cache.Set(ctx, key("swapped"), value{Name: "a"}, 200*time.Millisecond)
+	_, _ = cache.CompareAndSwap(ctx, key("swapped"), value{Name: "a"}, value{Name: "b"}, 0)
+	_ = cache.SetMany(ctx, map[string]any{key("many"): value{Name: "many"}}, 0)
+	_, _ = cache.Incr(ctx, key("counter"), 0)
+
+	// past the ttl the keys had before
+	s.sleep(400 * time.Millisecond)
+
+	for _, name := range []string{"set", "absent", "present", "swapped", "many", "counter"} {
+		assert.Truef(t, cache.Has(ctx, key(name)), "expected %s (ttl 0) not to expire", name)
+	}
+}
+
+func (s *suite) testDel(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("key"), value{Name: "a"}, time.Minute)
+	err := cache.Del(ctx, key("key"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	var actual value
+	err = cache.Get(ctx, key("key"), &actual)
+	assert.Truef(t, datastore.IsErrNotFound(err), "expected not found but got: %+v", err)
+}
+
+func (s *suite) testMany(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	err := cache.SetMany(ctx, map[string]any{key("1"): value{Name: "1"}, key("2"): value{Name: "2"}}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	var actual map[string]value
+	missing, err := cache.GetMany(ctx, []string{key("1"), key("3"), key("2")}, &actual)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, map[string]value{key("1"): {Name: "1"}, key("2"): {Name: "2"}}, actual)
+	assert.Equal(t, []string{key("3")}, missing)
+
+	err = cache.DelMany(ctx, key("1"), key("2"), key("3"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, cache.Has(ctx, key("1")))
+	assert.False(t, cache.Has(ctx, key("2")))
+}
+
+func (s *suite) testConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	set, err := cache.SetIfPresent(ctx, key("key"), value{Name: "a"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, set)
+
+	set, err = cache.SetIfAbsent(ctx, key("key"), value{Name: "a"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.True(t, set)
+
+	set, err = cache.SetIfAbsent(ctx, key("key"), value{Name: "b"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, set)
+
+	set, err = cache.SetIfPresent(ctx, key("key"), value{Name: "b"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.True(t, set)
+
+	swapped, err := cache.CompareAndSwap(ctx, key("key"), value{Name: "a"}, value{Name: "c"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, swapped)
+
+	swapped, err = cache.CompareAndSwap(ctx, key("key"), value{Name: "b"}, value{Name: "c"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.True(t, swapped)
+
+	var actual value
+	err = cache.GetAndDelete(ctx, key("key"), &actual)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, value{Name: "c"}, actual)
+	assert.False(t, cache.Has(ctx, key("key")))
+}
+
+func (s *suite) testTags(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+	tag := key("tag")
+
+	_ = cache.Set(ctx, key("1"), value{Name: "1"}, time.Minute, datastore.WithTags(tag))
+	_ = cache.Set(ctx, key("2"), value{Name: "2"}, time.Minute, datastore.WithTags(tag, key("other")))
+	_ = cache.Set(ctx, key("3"), value{Name: "3"}, time.Minute)
+
+	err := cache.InvalidateTag(ctx, tag)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, cache.Has(ctx, key("1")))
+	assert.False(t, cache.Has(ctx, key("2")))
+	assert.True(t, cache.Has(ctx, key("3")))
+}
+
+func (s *suite) testDelPrefix(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("users:1"), value{}, time.Minute)
+	_ = cache.Set(ctx, key("users:2"), value{}, time.Minute)
+	_ = cache.Set(ctx, key("users*"), value{}, time.Minute)
+	_ = cache.Set(ctx, key("orders:1"), value{}, time.Minute)
+
+	err := cache.DelPrefix(ctx, key("users*"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, cache.Has(ctx, key("users*")))
+	assert.True(t, cache.Has(ctx, key("users:1")))
+
+	err = cache.DelPrefix(ctx, key("users:"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, cache.Has(ctx, key("users:1")))
+	assert.False(t, cache.Has(ctx, key("users:2")))
+	assert.True(t, cache.Has(ctx, key("orders:1")))
+}
+
+func (s *suite) testCounters(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	n, err := cache.Incr(ctx, key("counter"), time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, int64(1), n)
+
+	n, _ = cache.IncrBy(ctx, key("counter"), 5, time.Minute)
+	assert.Equal(t, int64(6), n)
+	n, _ = cache.Decr(ctx, key("counter"), time.Minute)
+	assert.Equal(t, int64(5), n)
+	n, _ = cache.IncrBy(ctx, key("counter"), 0, time.Minute)
+	assert.Equal(t, int64(5), n)
+
+	// the ttl is set by the first increment only
+	_, _ = cache.Incr(ctx, key("window"), 200*time.Millisecond)
+	s.sleep(100 * time.Millisecond)
+	n, _ = cache.Incr(ctx, key("window"), 200*time.Millisecond)
+	assert.Equal(t, int64(2), n)
+	s.sleep(300 * time.Millisecond)
+	n, err = cache.Incr(ctx, key("window"), 200*time.Millisecond)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, int64(1), n)
+
+	// atomic
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, _ = cache.Incr(ctx, key("concurrent"), time.Minute)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, _ = cache.IncrBy(ctx, key("concurrent"), 0, time.Minute)
+	assert.Equal(t, int64(200), n)
+}
+
+func (s *suite) testConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	var (
+		wg      sync.WaitGroup
+		winners int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// ONE winner
+			if set, err := cache.SetIfAbsent(ctx, key("lock"), value{Count: i}, time.Minute); err == nil && set {
+				atomic.AddInt32(&winners, 1)
+			}
+
+			for j := 0; j < 20; j++ {
+				own := key(fmt.Sprintf("%d:%d", i, j))
+				if err := cache.Set(ctx, own, value{Count: j}, time.Minute); err != nil {
+					t.Errorf("setting %s: %s", own, err)
+					return
+				}
+
+				var actual value
+				if err := cache.Get(ctx, own, &actual); err != nil || actual.Count != j {
+					t.Errorf("getting %s: %+v, %v", own, actual, err)
+					return
+				}
+
+				_ = cache.Set(ctx, key("shared"), value{Count: j}, time.Minute)
+				_ = cache.Get(ctx, key("shared"), &actual)
+				_ = cache.Del(ctx, own)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&winners))
+}
+
+func (s *suite) testClear(t *testing.T) {
+	ctx := context.Background()
+	cache, key := s.cache(t)
+
+	_ = cache.Set(ctx, key("1"), value{}, time.Minute)
+	_ = cache.Set(ctx, key("2"), value{}, time.Minute, datastore.WithTags(key("tag")))
+
+	err := cache.Clear(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, cache.Has(ctx, key("1")))
+	assert.False(t, cache.Has(ctx, key("2")))
+
+	// usable after clearing
+	err = cache.Set(ctx, key("1"), value{Name: "again"}, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.True(t, cache.Has(ctx, key("1")))
+}
+
+func (s *suite) testClose(t *testing.T) {
+	cache, _ := s.cache(t)
+
+	err := cache.Close()
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+}
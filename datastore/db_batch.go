@@ -0,0 +1,396 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/schema"
+)
+
+// CreateInBatches inserts the records of a slice, sending batchSize records per statement.
+// All batches are inserted within ONE transaction, so either all or none are created.
+//
+// Usage: CreateInBatches(ctx, &[]Book{....}, 500)
+func (r *DBRepository) CreateInBatches(ctx context.Context, modelsPtr any, batchSize int) (int64, error) {
+	if batchSize < 1 {
+		return 0, errors.New("batch size must be greater than zero")
+	}
+
+	v := reflect.ValueOf(modelsPtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return 0, fmt.Errorf("model must be a pointer to a slice, got %T", modelsPtr)
+	}
+	slice := v.Elem()
+
+	if slice.Len() == 0 {
+		return 0, nil
+	}
+
+	var total int64
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		repo := r.NewWithTx(tx)
+
+		for start := 0; start < slice.Len(); start += batchSize {
+			end := start + batchSize
+			if end > slice.Len() {
+				end = slice.Len()
+			}
+
+			// the batch shares the backing array, so returned columns land in the caller's slice
+			batch := reflect.New(slice.Type())
+			batch.Elem().Set(slice.Slice(start, end))
+
+			n, err := repo.Create(ctx, batch.Interface(), false)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Each streams the records matching the criteria ONE row at a time (via a database cursor)
+// into modelPtr, calling fn after every row. Memory stays bounded regardless of the number of rows.
+//
+// modelPtr is reused for every row, so copy it if you need to keep it around.
+//
+//	book := Book{}
+//	err := repo.Each(ctx, &book, func(ctx context.Context) error {
+//		fmt.Println(book.Title)
+//		return nil
+//	})
+func (r *DBRepository) Each(ctx context.Context, modelPtr any, fn func(ctx context.Context) error, sc ...SelectCriteria) error {
+	v := reflect.ValueOf(modelPtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct, got %T", modelPtr)
+	}
+
+	q := r.db.NewSelect().Model(modelPtr)
+	if err := r.scopeSelect(ctx, q); err != nil {
+		return err
+	}
+
+	for i := range sc {
+		q.Apply(sc[i])
+	}
+
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	zero := reflect.Zero(v.Elem().Type())
+	for rows.Next() {
+		v.Elem().Set(zero)
+
+		if err := q.DB().ScanRow(ctx, rows, modelPtr); err != nil {
+			return err
+		}
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EachChunk loads the records matching the criteria in chunks of chunkSize into modelsPtr
+// (a pointer to a slice), calling fn after every chunk. It pages via the primary-key
+// (keyset pagination), so the criteria should only filter and not order or limit.
+//
+//	var books []Book
+//	err := repo.EachChunk(ctx, &books, 1000, func(ctx context.Context) error {
+//		return process(books)
+//	})
+func (r *DBRepository) EachChunk(
+	ctx context.Context, modelsPtr any, chunkSize int, fn func(ctx context.Context) error, sc ...SelectCriteria,
+) error {
+	if chunkSize < 1 {
+		return errors.New("chunk size must be greater than zero")
+	}
+
+	typ, _, err := modelStructs(modelsPtr)
+	if err != nil {
+		return err
+	}
+
+	slice := reflect.ValueOf(modelsPtr).Elem()
+	if slice.Kind() != reflect.Slice {
+		return fmt.Errorf("model must be a pointer to a slice, got %T", modelsPtr)
+	}
+
+	pks := r.db.Dialect().Tables().Get(typ).PKs
+	if len(pks) == 0 {
+		return fmt.Errorf("model %s has no primary key to page by", typ.Name())
+	}
+
+	var last []any
+
+	for {
+		slice.Set(reflect.MakeSlice(slice.Type(), 0, chunkSize))
+
+		q := r.db.NewSelect().Model(modelsPtr)
+		if err := r.scopeSelect(ctx, q); err != nil {
+			return err
+		}
+
+		for i := range sc {
+			q.Apply(sc[i])
+		}
+
+		if last != nil {
+			q.Where(keysetPredicate(pks), append(pkIdents(pks), last...)...)
+		}
+		for _, pk := range pks {
+			q.OrderExpr("?TableAlias.? ASC", pk.SQLName)
+		}
+
+		if err := q.Limit(chunkSize).Scan(ctx); err != nil {
+			return err
+		}
+
+		n := slice.Len()
+		if n == 0 {
+			return nil
+		}
+
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		if n < chunkSize {
+			return nil
+		}
+
+		lastRow := reflect.Indirect(slice.Index(n - 1))
+		last = make([]any, len(pks))
+		for i, pk := range pks {
+			last[i] = pk.Value(lastRow).Interface()
+		}
+	}
+}
+
+// keysetPredicate returns e.g '(?TableAlias.?, ?TableAlias.?) > (?, ?)' for the primary keys
+func keysetPredicate(pks []*schema.Field) string {
+	if len(pks) == 1 {
+		return "?TableAlias.? > ?"
+	}
+
+	cols := strings.TrimSuffix(strings.Repeat("?TableAlias.?, ", len(pks)), ", ")
+	vals := strings.TrimSuffix(strings.Repeat("?, ", len(pks)), ", ")
+	return "(" + cols + ") > (" + vals + ")"
+}
+
+func pkIdents(pks []*schema.Field) []any {
+	idents := make([]any, len(pks))
+	for i, pk := range pks {
+		idents[i] = pk.SQLName
+	}
+	return idents
+}
+
+// CopyFrom bulk loads the records of a slice via the postgresql COPY protocol. It is way faster
+// than an INSERT for large loads, but it is postgresql only, doesn't return columns,
+// skips auto-increment/identity columns and can't be used within a transaction.
+//
+// As on INSERT, nil pointers & zero values of nullzero fields are NULL, or left to the column's
+// default when the field has a default (or is notnull).
+//
+// Usage: CopyFrom(ctx, &[]Book{....})
+func (r *DBRepository) CopyFrom(ctx context.Context, modelsPtr any) (int64, error) {
+	db, ok := r.db.(*bun.DB)
+	if !ok || db.Dialect().Name() != dialect.PG {
+		return 0, errors.New("copy from is only supported on a postgresql connection (not a transaction)")
+	}
+
+	typ, structs, err := modelStructs(modelsPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	tenantID, scoped, err := r.tenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if scoped {
+		if err := r.setTenant(modelsPtr, tenantID); err != nil {
+			return 0, err
+		}
+	}
+
+	table := db.Dialect().Tables().Get(typ)
+	batches, err := copyBatches(table, structs)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// ONE COPY per set of columns: all or none within a transaction of the connection
+	if len(batches) > 1 {
+		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+			return 0, err
+		}
+	}
+
+	var total int64
+	for _, batch := range batches {
+		query := "COPY " + string(table.SQLName) + " (" + strings.Join(batch.cols, ", ") + ") FROM STDIN WITH (FORMAT csv, NULL '\\N')"
+		res, err := pgdriver.CopyFrom(ctx, conn, &batch.buf, query)
+		if err == nil {
+			var n int64
+			n, err = res.RowsAffected()
+			total += n
+		}
+		if err != nil {
+			if len(batches) > 1 {
+				_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+			}
+			return 0, err
+		}
+	}
+
+	if len(batches) > 1 {
+		if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// copyBatch is a COPY of the records having the same columns: the columns left to their default
+// are omitted
+type copyBatch struct {
+	cols []string
+	buf  bytes.Buffer
+}
+
+// copyBatches renders the records as csv, batched by columns (in order of first appearance)
+func copyBatches(table *schema.Table, structs []reflect.Value) ([]*copyBatch, error) {
+	var fields []*schema.Field
+	for _, f := range table.Fields {
+		if f.AutoIncrement || f.Identity {
+			continue
+		}
+		fields = append(fields, f)
+	}
+
+	var batches []*copyBatch
+	byColumns := make(map[string]*copyBatch)
+
+	for _, strct := range structs {
+		var cols []string
+		var record []byte
+		for _, f := range fields {
+			empty := (f.IsPtr && f.HasNilValue(strct)) || (f.NullZero && f.HasZeroValue(strct))
+			if empty && (f.SQLDefault != "" || f.NotNull) {
+				continue
+			}
+			cols = append(cols, string(f.SQLName))
+
+			if len(cols) > 1 {
+				record = append(record, ',')
+			}
+			if empty {
+				record = append(record, `\N`...)
+				continue
+			}
+
+			val, null, err := copyValue(f.Value(strct))
+			if err != nil {
+				return nil, fmt.Errorf("copy from %s.%s: %w", table.TypeName, f.Name, err)
+			}
+			record = appendCopyValue(record, val, null)
+		}
+		record = append(record, '\n')
+
+		key := strings.Join(cols, ",")
+		batch, ok := byColumns[key]
+		if !ok {
+			batch = &copyBatch{cols: cols}
+			byColumns[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.buf.Write(record)
+	}
+	return batches, nil
+}
+
+// appendCopyValue appends a csv value: NULL unquoted, & every other value quoted, so that a value
+// such as '\N' isn't read as NULL
+func appendCopyValue(b []byte, val string, null bool) []byte {
+	if null {
+		return append(b, `\N`...)
+	}
+	b = append(b, '"')
+	b = append(b, strings.ReplaceAll(val, `"`, `""`)...)
+	return append(b, '"')
+}
+
+// copyValue renders a struct field as a postgresql COPY csv value, or null
+func copyValue(v reflect.Value) (val string, null bool, err error) {
+	if !v.IsValid() {
+		return "", true, nil
+	}
+
+	raw := v.Interface()
+	if valuer, ok := raw.(driver.Valuer); ok {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return "", true, nil
+		}
+		dv, err := valuer.Value()
+		if err != nil {
+			return "", false, err
+		}
+		raw = dv
+	} else if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", true, nil
+		}
+		return copyValue(v.Elem())
+	}
+
+	switch raw := raw.(type) {
+	case nil:
+		return "", true, nil
+	case string:
+		return raw, false, nil
+	case []byte:
+		return `\x` + hex.EncodeToString(raw), false, nil
+	case bool:
+		return strconv.FormatBool(raw), false, nil
+	case time.Time:
+		return raw.Format(time.RFC3339Nano), false, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(raw), false, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Struct, reflect.Array:
+		b, err := json.Marshal(raw)
+		return string(b), false, err
+	}
+	return fmt.Sprint(raw), false, nil
+}
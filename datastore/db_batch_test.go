@@ -0,0 +1,125 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestDBRepository_CreateInBatches_Each_And_EachChunk(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:batch?mode=memory&cache=shared")
+	if err := crudRepo.Migrate(ctx, (*Book)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var seedBooks []Book
+	for i := 1; i <= 5; i++ {
+		seedBooks = append(seedBooks, Book{Id: fmt.Sprintf("book%d", i), Title: "hello"})
+	}
+
+	_, err := crudRepo.CreateInBatches(ctx, &seedBooks, 2)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// Each
+	var titles []string
+	book := Book{}
+	err = crudRepo.Each(ctx, &book, func(ctx context.Context) error {
+		titles = append(titles, book.Id)
+		return nil
+	}, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Order("id")
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want := []string{"book1", "book2", "book3", "book4", "book5"}
+	assert.Equalf(t, want, titles, "expected %+v but got: %+v", want, titles)
+
+	// EachChunk
+	var chunks [][]Book
+	var books []Book
+	err = crudRepo.EachChunk(ctx, &books, 2, func(ctx context.Context) error {
+		chunks = append(chunks, books)
+		return nil
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	wantChunks := [][]Book{seedBooks[0:2], seedBooks[2:4], seedBooks[4:5]}
+	assert.Equalf(t, wantChunks, chunks, "expected %+v but got: %+v", wantChunks, chunks)
+
+	// batches are inserted in one transaction
+	duplicates := []Book{{Id: "book6", Title: "hello"}, {Id: "book1", Title: "hello"}}
+	_, err = crudRepo.CreateInBatches(ctx, &duplicates, 1)
+	assert.NotNil(t, err, "expected a duplicate primary key error")
+
+	err = crudRepo.FindByPK(ctx, &Book{Id: "book6"})
+	assert.Truef(t, IsErrNotFound(err), "expected not found but got: %+v", err)
+}
+
+func TestDBRepository_CopyFrom_NotPostgres(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:batch?mode=memory&cache=shared")
+
+	_, err := crudRepo.CopyFrom(ctx, &[]Book{{Id: "book1"}})
+	assert.NotNil(t, err, "copy from should only work on postgresql")
+}
+
+func Test_copyValue(t *testing.T) {
+	title := "hello"
+	tests := []struct {
+		val  any
+		want string
+		null bool
+	}{
+		{val: "hello", want: "hello"},
+		{val: &title, want: "hello"},
+		{val: (*string)(nil), null: true},
+		{val: []byte("hi"), want: `\x6869`},
+		{val: true, want: "true"},
+		{val: 42, want: "42"},
+		{val: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), want: "2023-01-02T03:04:05Z"},
+		{val: map[string]int{"a": 1}, want: `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		got, null, err := copyValue(reflect.ValueOf(tt.val))
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		assert.Equalf(t, tt.want, got, "expected %+v but got: %+v", tt.want, got)
+		assert.Equalf(t, tt.null, null, "expected %+v but got: %+v", tt.null, null)
+	}
+}
+
+type Shipment struct {
+	Id        string    `bun:",pk"`
+	Note      string    `bun:",nullzero"`
+	ShippedAt time.Time `bun:",nullzero"`
+	Status    string    `bun:",nullzero,default:'pending'"`
+	Carrier   *string
+}
+
+func Test_copyBatches(t *testing.T) {
+	table := pgdialect.New().Tables().Get(reflect.TypeOf(Shipment{}))
+	shippedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	_, structs, err := modelStructs(&[]Shipment{
+		{Id: "s1", Note: `\N`, ShippedAt: shippedAt, Status: "shipped"},
+		// nullzero zero values: NULL, or the default of status
+		{Id: "s2"},
+		{Id: "s3", Note: `say "hi"`},
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	batches, err := copyBatches(table, structs)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 2, len(batches), "expected %+v but got: %+v", 2, len(batches))
+
+	// a literal \N is quoted, not NULL
+	assert.Equal(t, []string{`"id"`, `"note"`, `"shipped_at"`, `"status"`, `"carrier"`}, batches[0].cols)
+	assert.Equal(t, `"s1","\N","2023-01-02T03:04:05Z","shipped",\N`+"\n", batches[0].buf.String())
+
+	// status left out, to its default
+	assert.Equal(t, []string{`"id"`, `"note"`, `"shipped_at"`, `"carrier"`}, batches[1].cols)
+	assert.Equal(t, `"s2",\N,\N,\N`+"\n"+`"s3","say ""hi""",\N,\N`+"\n", batches[1].buf.String())
+}
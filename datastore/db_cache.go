@@ -0,0 +1,349 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/schema"
+)
+
+var _ IDBRepository = (*CachedRepository)(nil)
+
+// cacheTables resolves the table & primary-keys of models, whatever the database of the decorated repository
+var cacheTables = sqlitedialect.New().Tables()
+
+// CachedRepository is an IDBRepository decorator reading records by primary-key through an ICache
+// (cache-aside): FindByPK is served from the cache when possible, & records found (or not found, when
+// negative caching is enabled) are cached. Every other read goes to the database.
+//
+// Writes invalidate the cached records they touch. DeleteWhere, whose records are unknown, invalidates
+// every cached record of its model. Writes within Transactional are invalidated again after the commit,
+// so that a concurrent read can't cache the state before the commit for long.
+//
+// Keys are 'dbcache:<table>:<generation>:<tenant>:<primary-keys>', so tenants (& admins, see
+// WithTenantAdmin) never share entries; the generation of the table costs one more cache read per
+// operation. A tenant scoped repository isn't cached without a tenant in the context (ErrTenantMissing). The entries of a record are tagged
+// 'dbcache:<table>:<primary-keys>' (see WithTags): a write invalidates them for every tenant.
+// Records are cached with the codec of the cache (see WithCodec).
+type CachedRepository struct {
+	repo  IDBRepository
+	cache ICache
+
+	ttl         time.Duration
+	notFoundTTL time.Duration
+	modelTTLs   map[reflect.Type][2]time.Duration
+}
+
+// NewCachedRepository decorates repo with cache. Records are cached for ttl & not-found records for
+// notFoundTTL (0 disables negative caching).
+func NewCachedRepository(repo IDBRepository, cache ICache, ttl, notFoundTTL time.Duration) *CachedRepository {
+	return &CachedRepository{repo: repo, cache: cache, ttl: ttl, notFoundTTL: notFoundTTL}
+}
+
+// WithTTL returns a copy of the repository caching the records of model for ttl & its not-found
+// records for notFoundTTL (0 disables negative caching), instead of the default TTLs.
+//
+// Usage: WithTTL((*Settings)(nil), time.Hour, 0)
+func (r *CachedRepository) WithTTL(modelPtr any, ttl, notFoundTTL time.Duration) *CachedRepository {
+	clone := *r
+	clone.modelTTLs = make(map[reflect.Type][2]time.Duration, len(r.modelTTLs)+1)
+	for typ, ttls := range r.modelTTLs {
+		clone.modelTTLs[typ] = ttls
+	}
+	clone.modelTTLs[modelType(modelPtr)] = [2]time.Duration{ttl, notFoundTTL}
+	return &clone
+}
+
+func (r *CachedRepository) FindByPK(ctx context.Context, modelPtr any) error {
+	key, tag, err := r.recordKey(ctx, reflect.ValueOf(modelPtr))
+	if err != nil {
+		// not ONE struct (eg: a slice): uncached
+		return r.repo.FindByPK(ctx, modelPtr)
+	}
+
+	// the record itself (decoded aside: a failed decoding mustn't touch the model), or its absence
+	ttl, notFoundTTL := r.ttls(modelPtr)
+	cached := reflect.New(reflect.TypeOf(modelPtr).Elem())
+	if err := r.cache.Get(ctx, key, cached.Interface()); err == nil {
+		reflect.ValueOf(modelPtr).Elem().Set(cached.Elem())
+		return nil
+	}
+	if notFoundTTL > 0 && r.cache.Has(ctx, notFoundKey(key)) {
+		return sql.ErrNoRows
+	}
+
+	err = r.repo.FindByPK(ctx, modelPtr)
+	switch {
+	case err == nil:
+		_ = r.cache.Set(ctx, key, modelPtr, ttl, WithTags(tag))
+	case errors.Is(err, sql.ErrNoRows) && notFoundTTL > 0:
+		_ = r.cache.Set(ctx, notFoundKey(key), true, notFoundTTL, WithTags(tag))
+	}
+	return err
+}
+
+func (r *CachedRepository) Create(ctx context.Context, modelPtr any, ignoreDupicates bool) (int64, error) {
+	n, err := r.repo.Create(ctx, modelPtr, ignoreDupicates)
+	r.invalidate(ctx, modelPtr)
+	return n, err
+}
+
+func (r *CachedRepository) CreateInBatches(ctx context.Context, modelsPtr any, batchSize int) (int64, error) {
+	n, err := r.repo.CreateInBatches(ctx, modelsPtr, batchSize)
+	r.invalidate(ctx, modelsPtr)
+	return n, err
+}
+
+func (r *CachedRepository) Upsert(ctx context.Context, modelsPtr any, opts UpsertOptions) (UpsertResult, error) {
+	res, err := r.repo.Upsert(ctx, modelsPtr, opts)
+	if len(opts.ConflictColumns) > 0 || opts.ConflictConstraint != "" {
+		// the existing records may have other primary-keys
+		r.invalidateTable(ctx, modelsPtr)
+	}
+	r.invalidate(ctx, modelsPtr)
+	return res, err
+}
+
+func (r *CachedRepository) Update(ctx context.Context, modelsPtr any, mustExist bool) (int64, error) {
+	n, err := r.repo.Update(ctx, modelsPtr, mustExist)
+	r.invalidate(ctx, modelsPtr)
+	return n, err
+}
+
+func (r *CachedRepository) UpdateBulk(ctx context.Context, modelPtr any) (int64, error) {
+	n, err := r.repo.UpdateBulk(ctx, modelPtr)
+	r.invalidate(ctx, modelPtr)
+	return n, err
+}
+
+func (r *CachedRepository) DeleteByPK(ctx context.Context, modelsPtr any, mustExist bool) (int64, error) {
+	n, err := r.repo.DeleteByPK(ctx, modelsPtr, mustExist)
+	r.invalidate(ctx, modelsPtr)
+	return n, err
+}
+
+func (r *CachedRepository) DeleteWhere(ctx context.Context, modelsPtr any, dc ...DeleteCriteria) (int64, error) {
+	n, err := r.repo.DeleteWhere(ctx, modelsPtr, dc...)
+	r.invalidateTable(ctx, modelsPtr)
+	return n, err
+}
+
+func (r *CachedRepository) Migrate(ctx context.Context, modelsPtr ...any) error {
+	return r.repo.Migrate(ctx, modelsPtr...)
+}
+
+func (r *CachedRepository) FindWhere(ctx context.Context, modelPtr any, sc ...SelectCriteria) error {
+	return r.repo.FindWhere(ctx, modelPtr, sc...)
+}
+
+func (r *CachedRepository) List(ctx context.Context, modelPtr any, sc ...SelectCriteria) error {
+	return r.repo.List(ctx, modelPtr, sc...)
+}
+
+func (r *CachedRepository) Each(ctx context.Context, modelPtr any, fn func(ctx context.Context) error, sc ...SelectCriteria) error {
+	return r.repo.Each(ctx, modelPtr, fn, sc...)
+}
+
+func (r *CachedRepository) EachChunk(
+	ctx context.Context, modelsPtr any, chunkSize int, fn func(ctx context.Context) error, sc ...SelectCriteria,
+) error {
+	return r.repo.EachChunk(ctx, modelsPtr, chunkSize, fn, sc...)
+}
+
+func (r *CachedRepository) NewWithTx(tx bun.Tx) IDBRepository {
+	clone := *r
+	clone.repo = r.repo.NewWithTx(tx)
+	return &clone
+}
+
+func (r *CachedRepository) Transactional(ctx context.Context, fn func(ctx context.Context, tx bun.Tx) error) error {
+	pending := &pendingInvalidations{}
+	ctx = context.WithValue(ctx, pendingInvalidationsKey{}, pending)
+
+	if err := r.repo.Transactional(ctx, fn); err != nil {
+		return err
+	}
+
+	_ = r.cache.InvalidateTag(ctx, pending.tags...)
+	for _, model := range pending.tables {
+		r.invalidateTable(context.WithValue(ctx, pendingInvalidationsKey{}, nil), model)
+	}
+	return nil
+}
+
+// pendingInvalidations collects the invalidations of a transaction, to repeat after its commit
+type pendingInvalidations struct {
+	mu     sync.Mutex
+	tags   []string
+	tables []any
+}
+
+type pendingInvalidationsKey struct{}
+
+func pendingFromContext(ctx context.Context) *pendingInvalidations {
+	pending, _ := ctx.Value(pendingInvalidationsKey{}).(*pendingInvalidations)
+	return pending
+}
+
+func (r *CachedRepository) invalidate(ctx context.Context, model any) {
+	_, structs, err := modelStructs(model)
+	if err != nil {
+		return
+	}
+
+	var tags []string
+	for _, v := range structs {
+		if tag, err := recordTag(v.Addr()); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	// every tenant's entries
+	_ = r.cache.InvalidateTag(ctx, tags...)
+	if pending := pendingFromContext(ctx); pending != nil {
+		pending.mu.Lock()
+		pending.tags = append(pending.tags, tags...)
+		pending.mu.Unlock()
+	}
+}
+
+// invalidateTable invalidates every record of the model's table, by moving to a new generation of keys
+func (r *CachedRepository) invalidateTable(ctx context.Context, model any) {
+	typ := modelType(model)
+	if typ == nil {
+		return
+	}
+
+	// the generation must outlive the records cached under the previous one
+	ttl := r.ttl
+	for _, ttls := range r.modelTTLs {
+		if ttls[0] > ttl {
+			ttl = ttls[0]
+		}
+	}
+	if r.notFoundTTL > ttl {
+		ttl = r.notFoundTTL
+	}
+
+	key := generationKey(cacheTables.Get(typ))
+	_ = r.cache.Set(ctx, key, strconv.FormatInt(time.Now().UnixNano(), 36), 2*ttl)
+
+	if pending := pendingFromContext(ctx); pending != nil {
+		pending.mu.Lock()
+		pending.tables = append(pending.tables, model)
+		pending.mu.Unlock()
+	}
+}
+
+// recordKey is the cache key of the record in the struct pointed by v (by primary-keys), & the tag of
+// its entries
+func (r *CachedRepository) recordKey(ctx context.Context, v reflect.Value) (key, tag string, err error) {
+	table, pks, err := recordPKs(v)
+	if err != nil {
+		return "", "", err
+	}
+
+	tenant, err := r.cacheTenant(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	generation := ""
+	_ = r.cache.Get(ctx, generationKey(table), &generation)
+
+	key = strings.Join([]string{"dbcache", table.Name, generation, tenant, pks}, ":")
+	return key, "dbcache:" + table.Name + ":" + pks, nil
+}
+
+// tenantScoper is implemented by the repositories scoping their queries by tenant (DBRepository)
+type tenantScoper interface {
+	tenant(ctx context.Context) (tenantID string, scoped bool, err error)
+}
+
+// cacheTenant is the tenant segment of the cache keys of ctx: admins read across tenants, so their
+// entries are apart from those of the tenants & of the contexts without tenant
+func (r *CachedRepository) cacheTenant(ctx context.Context) (string, error) {
+	if IsTenantAdmin(ctx) {
+		return "admin", nil
+	}
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return "tenant=" + tenant, nil
+	}
+
+	// no tenant: only cached when the repository isn't scoped by tenant
+	if scoper, ok := r.repo.(tenantScoper); ok {
+		if _, _, err := scoper.tenant(ctx); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// recordTag is the tag of the cache entries of the record in the struct pointed by v, of every
+// tenant & generation
+func recordTag(v reflect.Value) (string, error) {
+	table, pks, err := recordPKs(v)
+	if err != nil {
+		return "", err
+	}
+	return "dbcache:" + table.Name + ":" + pks, nil
+}
+
+// recordPKs returns the table & the rendered primary-keys of the struct pointed by v
+func recordPKs(v reflect.Value) (*schema.Table, string, error) {
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, "", fmt.Errorf("model must be a non-nil pointer to a struct, got %s", v.Type())
+	}
+	v = v.Elem()
+
+	table := cacheTables.Get(v.Type())
+	if len(table.PKs) == 0 {
+		return nil, "", fmt.Errorf("model %s has no primary-key", table.TypeName)
+	}
+
+	pks := make([]string, len(table.PKs))
+	for i, pk := range table.PKs {
+		pks[i] = fmt.Sprint(pk.Value(v).Interface())
+	}
+	return table, strings.Join(pks, ":"), nil
+}
+
+// notFoundKey is the cache key of the absence of the record of key (negative caching)
+func notFoundKey(key string) string {
+	return key + ":notfound"
+}
+
+func (r *CachedRepository) ttls(model any) (ttl, notFoundTTL time.Duration) {
+	if ttls, ok := r.modelTTLs[modelType(model)]; ok {
+		return ttls[0], ttls[1]
+	}
+	return r.ttl, r.notFoundTTL
+}
+
+func generationKey(table *schema.Table) string {
+	return "dbcache:" + table.Name + ":generation"
+}
+
+// modelType is the struct type of a model: pointer to a struct or to a slice of structs
+func modelType(model any) reflect.Type {
+	typ := reflect.TypeOf(model)
+	for typ != nil && (typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice) {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return typ
+}
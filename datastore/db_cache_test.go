@@ -0,0 +1,158 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestCachedRepository(t *testing.T) {
+	ctx, db, repo := setUp("file:cached?mode=memory&cache=shared")
+	if err := repo.Migrate(ctx, (*Book)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cached := NewCachedRepository(repo, NewGoCache(), time.Minute, time.Minute)
+
+	_, err := cached.Create(ctx, &Book{Id: "book1", Title: "hello"}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// read through: served from the cache once cached
+	book := Book{Id: "book1"}
+	err = cached.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	_, err = db.NewUpdate().Model(&Book{Id: "book1", Title: "changed behind the cache"}).WherePK().Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	book = Book{Id: "book1"}
+	err = cached.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "hello", book.Title)
+
+	// writes invalidate
+	_, err = cached.Update(ctx, &Book{Id: "book1", Title: "updated"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	book = Book{Id: "book1"}
+	_ = cached.FindByPK(ctx, &book)
+	assert.Equal(t, "updated", book.Title)
+
+	// negative caching, invalidated by Create
+	err = cached.FindByPK(ctx, &Book{Id: "book2"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	_, err = db.NewInsert().Model(&Book{Id: "book2", Title: "behind the cache"}).Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	err = cached.FindByPK(ctx, &Book{Id: "book2"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	_, err = cached.Upsert(ctx, &[]Book{{Id: "book2", Title: "world"}}, UpsertOptions{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	book = Book{Id: "book2"}
+	err = cached.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "world", book.Title)
+
+	// DeleteWhere invalidates the whole model
+	_, err = cached.DeleteWhere(ctx, (*Book)(nil), func(q *bun.DeleteQuery) *bun.DeleteQuery {
+		return q.Where("title = ?", "world")
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	err = cached.FindByPK(ctx, &Book{Id: "book2"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	// transactions
+	err = cached.Transactional(ctx, func(ctx context.Context, tx bun.Tx) error {
+		_, err := cached.NewWithTx(tx).DeleteByPK(ctx, &Book{Id: "book1"}, true)
+		return err
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	err = cached.FindByPK(ctx, &Book{Id: "book1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+}
+
+func TestCachedRepository_WithTTL_And_Tenants(t *testing.T) {
+	ctx, _, repo := setUp("file:cached_ttl?mode=memory&cache=shared")
+	if err := repo.Migrate(ctx, (*Invoice)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// negative caching disabled for invoices
+	cached := NewCachedRepository(repo.WithTenantColumn("tenant_id"), NewGoCache(), time.Minute, time.Minute).
+		WithTTL((*Invoice)(nil), time.Minute, 0)
+
+	tenantA, tenantB := WithTenant(ctx, "tenantA"), WithTenant(ctx, "tenantB")
+
+	err := cached.FindByPK(tenantA, &Invoice{Id: "inv1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	_, err = repo.Create(ctx, &Invoice{Id: "inv1", TenantId: "tenantA", Amount: 10}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	invoice := Invoice{Id: "inv1"}
+	err = cached.FindByPK(tenantA, &invoice)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, 10, invoice.Amount)
+
+	// tenants don't share entries
+	err = cached.FindByPK(tenantB, &Invoice{Id: "inv1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	// writes without the tenant (eg: by an admin) invalidate the entries of every tenant
+	_, err = cached.Update(WithTenantAdmin(ctx), &Invoice{Id: "inv1", TenantId: "tenantA", Amount: 20}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	invoice = Invoice{Id: "inv1"}
+	err = cached.FindByPK(tenantA, &invoice)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, 20, invoice.Amount)
+
+	// admin reads are cached apart: never served without a tenant
+	invoice = Invoice{Id: "inv1"}
+	err = cached.FindByPK(WithTenantAdmin(ctx), &invoice)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, 20, invoice.Amount)
+
+	err = cached.FindByPK(ctx, &Invoice{Id: "inv1"})
+	assert.Equalf(t, ErrTenantMissing, err, "expected %+v but got: %+v", ErrTenantMissing, err)
+	err = cached.FindByPK(tenantB, &Invoice{Id: "inv1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+}
+
+// Secret isn't (un)marshalled by encoding/json
+type Secret struct {
+	Id     string `bun:",pk"`
+	Value  string `json:"-"`
+	Expiry time.Time
+}
+
+func TestCachedRepository_Codec(t *testing.T) {
+	ctx, db, repo := setUp("file:cached_codec?mode=memory&cache=shared")
+	if err := repo.Migrate(ctx, (*Secret)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cached := NewCachedRepository(repo, NewGoCache(), time.Minute, 0)
+	expected := Secret{Id: "s1", Value: "hunter2", Expiry: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}
+	_, err := cached.Create(ctx, &expected, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// a cache hit returns the record a database hit does
+	fromDB := Secret{Id: "s1"}
+	err = cached.FindByPK(ctx, &fromDB)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	_, err = db.NewDelete().Model((*Secret)(nil)).Where("id = ?", "s1").Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	fromCache := Secret{Id: "s1"}
+	err = cached.FindByPK(ctx, &fromCache)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, fromDB, fromCache, "expected %+v but got: %+v", fromDB, fromCache)
+	assert.Equal(t, "hunter2", fromCache.Value)
+}
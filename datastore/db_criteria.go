@@ -0,0 +1,179 @@
+package datastore
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/otyang/go-pkg/response"
+	"github.com/uptrace/bun"
+)
+
+// FilterOperator is a comparison a client can request in a filter e.g filter[amount][gte]=10
+type FilterOperator string
+
+const (
+	FilterEq   FilterOperator = "eq"
+	FilterNe   FilterOperator = "ne"
+	FilterGt   FilterOperator = "gt"
+	FilterGte  FilterOperator = "gte"
+	FilterLt   FilterOperator = "lt"
+	FilterLte  FilterOperator = "lte"
+	FilterLike FilterOperator = "like"
+	FilterIn   FilterOperator = "in"
+	FilterNull FilterOperator = "null"
+)
+
+func (o FilterOperator) String() string {
+	return string(o)
+}
+
+func (o FilterOperator) IsValid() bool {
+	switch o {
+	case FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte, FilterLike, FilterIn, FilterNull:
+		return true
+	default:
+		return false
+	}
+}
+
+// sql returns the sql comparison of the operator
+func (o FilterOperator) sql() string {
+	switch o {
+	case FilterNe:
+		return "<>"
+	case FilterGt:
+		return ">"
+	case FilterGte:
+		return ">="
+	case FilterLt:
+		return "<"
+	case FilterLte:
+		return "<="
+	case FilterLike:
+		return "LIKE"
+	default:
+		return "="
+	}
+}
+
+// QueryAllowlist lists the columns of a model a client is allowed to filter & sort by.
+// Anything outside the list is rejected, so user input never reaches the query as an identifier.
+type QueryAllowlist struct {
+	Filterable []string
+	Sortable   []string
+}
+
+var filterKeyRegex = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([^\[\]]+)\])?$`)
+
+// ParseQueryCriteria turns query strings of the form:
+//
+//	?filter[status]=active&filter[created_at][gte]=2023-01-01&filter[id][in]=1,2&sort=-created_at,title
+//
+// into criteria usable with FindWhere or List. Filters without an operator default to 'eq',
+// sort fields prefixed with '-' are sorted descending.
+//
+// Fields or operators not allowed come back as a 400 response, ready to be sent to the client.
+func ParseQueryCriteria(query url.Values, allow QueryAllowlist) ([]SelectCriteria, *response.Response) {
+	var criteria []SelectCriteria
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "sort" {
+			continue
+		}
+
+		match := filterKeyRegex.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+
+		column, op := match[1], FilterOperator(match[2])
+		if op == "" {
+			op = FilterEq
+		}
+
+		if !contains(allow.Filterable, column) {
+			return nil, response.NewError(http.StatusBadRequest, "filtering by '"+column+"' is not supported", "invalid_filter")
+		}
+		if !op.IsValid() {
+			return nil, response.NewError(http.StatusBadRequest, "filter operator '"+op.String()+"' is not supported", "invalid_filter")
+		}
+
+		for _, value := range query[key] {
+			c, rsp := filterCriteria(column, op, value)
+			if rsp != nil {
+				return nil, rsp
+			}
+			criteria = append(criteria, c)
+		}
+	}
+
+	for _, value := range query["sort"] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			direction := "ASC"
+			if strings.HasPrefix(field, "-") {
+				field, direction = field[1:], "DESC"
+			}
+
+			if !contains(allow.Sortable, field) {
+				return nil, response.NewError(http.StatusBadRequest, "sorting by '"+field+"' is not supported", "invalid_sort")
+			}
+
+			column := field
+			criteria = append(criteria, func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.OrderExpr("?TableAlias.? "+direction, bun.Ident(column))
+			})
+		}
+	}
+
+	return criteria, nil
+}
+
+func filterCriteria(column string, op FilterOperator, value string) (SelectCriteria, *response.Response) {
+	switch op {
+	case FilterIn:
+		values := strings.Split(value, ",")
+		return func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.? IN (?)", bun.Ident(column), bun.In(values))
+		}, nil
+
+	case FilterNull:
+		switch value {
+		case "true", "1":
+			return func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("?TableAlias.? IS NULL", bun.Ident(column))
+			}, nil
+		case "false", "0":
+			return func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("?TableAlias.? IS NOT NULL", bun.Ident(column))
+			}, nil
+		}
+		return nil, response.NewError(http.StatusBadRequest, "filter '"+column+"' expects true or false", "invalid_filter")
+	}
+
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("?TableAlias.? "+op.sql()+" ?", bun.Ident(column), value)
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
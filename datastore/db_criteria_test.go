@@ -0,0 +1,75 @@
+package datastore
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueryCriteria(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:criteria?mode=memory&cache=shared")
+	if err := crudRepo.Migrate(ctx, (*Book)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	seedBooks := []Book{
+		{Id: "book1", Title: "hello"},
+		{Id: "book2", Title: "hello world"},
+		{Id: "book3", Title: "goodbye"},
+	}
+	if _, err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	allow := QueryAllowlist{Filterable: []string{"id", "title"}, Sortable: []string{"id"}}
+
+	query, _ := url.ParseQuery("filter[title][like]=hello%25&sort=-id")
+	criteria, rsp := ParseQueryCriteria(query, allow)
+	assert.Nilf(t, rsp, "expected nil but got: %+v", rsp)
+
+	var books []Book
+	err := crudRepo.List(ctx, &books, criteria...)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want := []Book{seedBooks[1], seedBooks[0]}
+	assert.Equalf(t, want, books, "expected %+v but got: %+v", want, books)
+
+	query, _ = url.ParseQuery("filter[id][in]=book1,book3&filter[title]=goodbye")
+	criteria, rsp = ParseQueryCriteria(query, allow)
+	assert.Nilf(t, rsp, "expected nil but got: %+v", rsp)
+
+	books = nil
+	err = crudRepo.List(ctx, &books, criteria...)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want = []Book{seedBooks[2]}
+	assert.Equalf(t, want, books, "expected %+v but got: %+v", want, books)
+}
+
+func TestParseQueryCriteria_NotAllowed(t *testing.T) {
+	allow := QueryAllowlist{Filterable: []string{"title"}, Sortable: []string{"id"}}
+
+	tests := []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{name: "unknown filter field", query: "filter[secret]=1", code: "invalid_filter"},
+		{name: "unknown filter operator", query: "filter[title][regex]=a", code: "invalid_filter"},
+		{name: "bad null value", query: "filter[title][null]=maybe", code: "invalid_filter"},
+		{name: "unknown sort field", query: "sort=-title", code: "invalid_sort"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			criteria, rsp := ParseQueryCriteria(query, allow)
+
+			assert.Nil(t, criteria)
+			if assert.NotNil(t, rsp) {
+				assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+				assert.Equal(t, tt.code, *rsp.ErrorCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package datastore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keyring holds the AES keys (16, 24 or 32 bytes) of the encrypted columns, by id. The keys aren't
+// used as is: the encryption & the synthetic nonces of DeterministicString each use a subkey derived
+// with HKDF-SHA256.
+// Rotating keys is adding a new current key: values encrypted with older keys stay readable & are
+// re-encrypted with the current key when saved again.
+type Keyring interface {
+	// CurrentKey returns the key (& its id) encrypting new values
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key of id, decrypting the values encrypted with it
+	Key(id string) ([]byte, error)
+}
+
+var (
+	ErrKeyringMissing = errors.New("no keyring set for encrypted columns, see SetKeyring")
+	ErrKeyNotFound    = errors.New("encryption key not found")
+)
+
+var (
+	keyringMu sync.RWMutex
+	keyring   Keyring
+)
+
+// SetKeyring sets the keyring of the encrypted columns (EncryptedString & DeterministicString)
+func SetKeyring(k Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+func getKeyring() (Keyring, error) {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	if keyring == nil {
+		return nil, ErrKeyringMissing
+	}
+	return keyring, nil
+}
+
+// StaticKeyring is a Keyring of keys known at start (eg: loaded from the config or a secret manager)
+type StaticKeyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewStaticKeyring returns a keyring of keys (by id), encrypting with the key currentID
+func NewStaticKeyring(currentID string, keys map[string][]byte) (*StaticKeyring, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("current key %q: %w", currentID, ErrKeyNotFound)
+	}
+
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+	}
+
+	return &StaticKeyring{currentID: currentID, keys: keys}, nil
+}
+
+func (k *StaticKeyring) CurrentKey() (string, []byte, error) {
+	return k.currentID, k.keys[k.currentID], nil
+}
+
+func (k *StaticKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", id, ErrKeyNotFound)
+	}
+	return key, nil
+}
+
+// EncryptedString is a string column encrypted with AES-GCM (random nonce) with the current key of
+// the keyring, & decrypted on scan. The same value encrypts differently every time: use
+// DeterministicString for columns looked up by equality.
+//
+//	type User struct {
+//		Id    string `bun:",pk"`
+//		Phone datastore.EncryptedString
+//	}
+//
+// Values are stored as '<mode>:<key id>:<base64 nonce+ciphertext>'. Values without this prefix are read
+// as plaintext, to encrypt existing columns by saving their records again. The prefixes 'enc:<key id>:'
+// & 'det:<key id>:' are reserved: a plaintext starting like one is read as encrypted, & fails (eg:
+// ErrKeyNotFound).
+type EncryptedString string
+
+func (s EncryptedString) Value() (driver.Value, error) {
+	return encryptColumn(string(s), false)
+}
+
+func (s *EncryptedString) Scan(src any) error {
+	plain, err := decryptColumn(src)
+	*s = EncryptedString(plain)
+	return err
+}
+
+// DeterministicString is a string column encrypted like EncryptedString, except that equal values
+// (with the same key) encrypt equally, allowing equality lookups:
+//
+//	q.Where("email = ?", datastore.DeterministicString("a@b.c"))
+//
+// It reveals which records share a value, so use it for lookup columns only. Lookups only match the
+// values encrypted with the current key: re-save the records after rotating keys.
+type DeterministicString string
+
+func (s DeterministicString) Value() (driver.Value, error) {
+	return encryptColumn(string(s), true)
+}
+
+func (s *DeterministicString) Scan(src any) error {
+	plain, err := decryptColumn(src)
+	*s = DeterministicString(plain)
+	return err
+}
+
+const (
+	encryptedPrefix     = "enc"
+	deterministicPrefix = "det"
+)
+
+func encryptColumn(plain string, deterministic bool) (string, error) {
+	k, err := getKeyring()
+	if err != nil {
+		return "", err
+	}
+	id, key, err := k.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := encryptedPrefix
+	nonce := make([]byte, gcm.NonceSize())
+	if deterministic {
+		// the nonce is derived from the value (synthetic IV): same value, same nonce & ciphertext
+		prefix = deterministicPrefix
+		sivKey, err := subkey(key, sivLabel, sha256.Size)
+		if err != nil {
+			return "", err
+		}
+		mac := hmac.New(sha256.New, sivKey)
+		mac.Write([]byte(plain))
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return prefix + ":" + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptColumn(src any) (string, error) {
+	var stored string
+	switch v := src.(type) {
+	case nil:
+		return "", nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return "", fmt.Errorf("encrypted column: unsupported type %T", src)
+	}
+
+	parts := strings.SplitN(stored, ":", 3)
+	if len(parts) != 3 || (parts[0] != encryptedPrefix && parts[0] != deterministicPrefix) {
+		// not encrypted yet
+		return stored, nil
+	}
+
+	k, err := getKeyring()
+	if err != nil {
+		return "", err
+	}
+	key, err := k.Key(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("encrypted column: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted column: ciphertext too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("encrypted column: %w", err)
+	}
+	return string(plain), nil
+}
+
+// the labels of the subkeys of a key: encrypting & deriving synthetic nonces
+const (
+	encLabel = "enc"
+	sivLabel = "siv"
+)
+
+// subkey derives the subkey of label (size bytes) from key, with HKDF-SHA256
+func subkey(key []byte, label string, size int) ([]byte, error) {
+	sub := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(label)), sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// newGCM returns the AES-GCM cipher of the encryption subkey of key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	encKey, err := subkey(key, encLabel, len(key))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
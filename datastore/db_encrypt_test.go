@@ -0,0 +1,132 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+type Patient struct {
+	Id    string `bun:",pk"`
+	Email DeterministicString
+	Notes EncryptedString
+}
+
+func TestEncryptedColumns(t *testing.T) {
+	ctx, db, crudRepo := setUp("file:encrypt?mode=memory&cache=shared")
+	defer SetKeyring(nil)
+
+	err := crudRepo.Migrate(ctx, (*Patient)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// no keyring: bun renders the error into the query, which fails
+	_, err = crudRepo.Create(ctx, &Patient{Id: "p0", Notes: "secret"}, false)
+	assert.NotNilf(t, err, "expected an error without keyring")
+
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	keyring, err := NewStaticKeyring("k1", map[string][]byte{"k1": k1})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	SetKeyring(keyring)
+
+	seed := []Patient{
+		{Id: "p1", Email: "a@b.c", Notes: "allergic"},
+		{Id: "p2", Email: "x@y.z", Notes: "allergic"},
+	}
+	_, err = crudRepo.Create(ctx, &seed, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// stored encrypted
+	var raw []struct{ Email, Notes string }
+	err = db.NewRaw("SELECT email, notes FROM patients ORDER BY id").Scan(ctx, &raw)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Truef(t, strings.HasPrefix(raw[0].Notes, "enc:k1:"), "expected encrypted notes, got: %s", raw[0].Notes)
+	assert.Truef(t, strings.HasPrefix(raw[0].Email, "det:k1:"), "expected encrypted email, got: %s", raw[0].Email)
+	assert.NotEqualf(t, raw[0].Notes, raw[1].Notes, "expected random nonces for equal values")
+
+	// decrypted on scan & looked up by equality
+	patient := Patient{}
+	err = crudRepo.FindWhere(ctx, &patient, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("email = ?", DeterministicString("x@y.z"))
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, Patient{Id: "p2", Email: "x@y.z", Notes: "allergic"}, patient)
+
+	// rotation: old values stay readable, new ones use the current key
+	keyring, err = NewStaticKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	SetKeyring(keyring)
+
+	_, err = crudRepo.Update(ctx, &Patient{Id: "p2", Email: "x@y.z", Notes: "rotated"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	var patients []Patient
+	err = crudRepo.List(ctx, &patients, func(q *bun.SelectQuery) *bun.SelectQuery { return q.Order("id") })
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, []Patient{{Id: "p1", Email: "a@b.c", Notes: "allergic"}, {Id: "p2", Email: "x@y.z", Notes: "rotated"}}, patients)
+
+	// plaintext values (not yet encrypted) are read as is
+	_, err = db.NewRaw("INSERT INTO patients (id, email, notes) VALUES ('p3', 'plain@b.c', 'plain')").Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	patient = Patient{Id: "p3"}
+	err = crudRepo.FindByPK(ctx, &patient)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, EncryptedString("plain"), patient.Notes)
+
+	// a plaintext using the reserved prefix is read as encrypted
+	var reserved EncryptedString
+	err = reserved.Scan("enc:k9:not encrypted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// unknown key
+	SetKeyring(&StaticKeyring{currentID: "k3", keys: map[string][]byte{"k3": k1}})
+	err = crudRepo.FindByPK(ctx, &Patient{Id: "p1"})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func Test_encryptColumn_Subkeys(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	keyring, err := NewStaticKeyring("k1", map[string][]byte{"k1": key})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	SetKeyring(keyring)
+	defer SetKeyring(nil)
+
+	stored, err := encryptColumn("a@b.c", true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, "det:k1:"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// the synthetic nonce comes from the 'siv' subkey, not the key
+	sivKey, err := subkey(key, sivLabel, sha256.Size)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	for k, expected := range map[string]bool{string(key): false, string(sivKey): true} {
+		mac := hmac.New(sha256.New, []byte(k))
+		mac.Write([]byte("a@b.c"))
+		assert.Equal(t, expected, bytes.Equal(mac.Sum(nil)[:12], sealed[:12]))
+	}
+
+	// encrypted with the 'enc' subkey, not the key
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	_, err = gcm.Open(nil, sealed[:12], sealed[12:], nil)
+	assert.NotNilf(t, err, "expected the key not to decrypt the value")
+
+	plain, err := decryptColumn(stored)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "a@b.c", plain)
+}
+
+func TestNewStaticKeyring(t *testing.T) {
+	_, err := NewStaticKeyring("missing", map[string][]byte{"k1": make([]byte, 32)})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = NewStaticKeyring("k1", map[string][]byte{"k1": make([]byte, 10)})
+	assert.NotNilf(t, err, "expected an invalid key size error")
+}
@@ -0,0 +1,286 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/otyang/go-pkg/utils"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures loads yaml or json fixture files into registered models. Handy for seeding
+// a database & for resetting it between tests, on both sqlite and postgresql.
+//
+// A fixture file maps table names to the rows to insert, keyed by column name:
+//
+//	authors:
+//	  - id: "{{ randomID 10 }}"
+//	    name: "J. R. R. Tolkien"
+//	    created_at: "{{ now }}"
+//	books:
+//	  - id: book1
+//	    title: hello
+//	    expires_at: "{{ nowAdd \"24h\" }}"
+//
+// Files are templates (text/template), with the helpers: now, nowAdd (duration) & randomID (length).
+// Tables are inserted in dependency order, deduced from the bun relations of the models.
+type Fixtures struct {
+	db     bun.IDB
+	tables []*schema.Table // in dependency order
+}
+
+// NewFixtures creates a fixtures loader for the models.
+//
+// Usage: NewFixtures(db, (*Author)(nil), (*Book)(nil), .....)
+func NewFixtures(db bun.IDB, modelsPtr ...any) (*Fixtures, error) {
+	tables := make([]*schema.Table, 0, len(modelsPtr))
+	for _, model := range modelsPtr {
+		typ := reflect.TypeOf(model)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("fixtures: model must be a pointer to a struct, got %T", model)
+		}
+		tables = append(tables, db.Dialect().Tables().Get(typ))
+	}
+
+	tables, err := sortTablesByDependency(tables)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fixtures{db: db, tables: tables}, nil
+}
+
+// Load loads ONE OR MORE fixture files (.yml, .yaml or .json)
+func (f *Fixtures) Load(ctx context.Context, files ...string) error {
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := f.LoadBytes(ctx, b); err != nil {
+			return fmt.Errorf("fixtures: %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// LoadBytes loads a yaml or json fixture (json being a subset of yaml)
+func (f *Fixtures) LoadBytes(ctx context.Context, data []byte) error {
+	tmpl, err := template.New("fixture").Funcs(fixtureFuncs()).Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return err
+	}
+
+	var fixture map[string][]map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &fixture); err != nil {
+		return err
+	}
+
+	for name := range fixture {
+		if f.table(name) == nil {
+			return fmt.Errorf("table %q is not registered", name)
+		}
+	}
+
+	return f.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, table := range f.tables {
+			rows, ok := fixture[table.Name]
+			if !ok || len(rows) == 0 {
+				continue
+			}
+
+			models, err := fixtureModels(table, rows)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.NewInsert().Model(models).Exec(ctx); err != nil {
+				return fmt.Errorf("%s: %w", table.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Reset empties the tables of the registered models, dependants first.
+// Postgresql identity/serial sequences are restarted.
+func (f *Fixtures) Reset(ctx context.Context) error {
+	for i := len(f.tables) - 1; i >= 0; i-- {
+		model := reflect.New(f.tables[i].Type).Interface()
+		if _, err := f.db.NewTruncateTable().Model(model).Cascade().Exec(ctx); err != nil {
+			return fmt.Errorf("fixtures: resetting %s: %w", f.tables[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (f *Fixtures) table(name string) *schema.Table {
+	for _, table := range f.tables {
+		if table.Name == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// fixtureModels returns a pointer to a slice of the table's model, filled with the rows
+func fixtureModels(table *schema.Table, rows []map[string]any) (any, error) {
+	slice := reflect.MakeSlice(reflect.SliceOf(table.Type), len(rows), len(rows))
+
+	for i, row := range rows {
+		strct := slice.Index(i)
+		for column, value := range row {
+			field, ok := table.FieldMap[column]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown column %q", table.Name, column)
+			}
+
+			v, err := fixtureValue(value)
+			if err != nil {
+				return nil, err
+			}
+			if err := field.ScanValue(strct, v); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", table.Name, column, err)
+			}
+		}
+	}
+
+	models := reflect.New(slice.Type())
+	models.Elem().Set(slice)
+	return models.Interface(), nil
+}
+
+// fixtureValue converts a decoded yaml value to what the bun scanners expect
+func fixtureValue(v any) (any, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case map[string]any, []any:
+		return json.Marshal(v)
+	}
+	return v, nil
+}
+
+func fixtureFuncs() template.FuncMap {
+	return template.FuncMap{
+		"now": func() string {
+			return time.Now().UTC().Format(time.RFC3339Nano)
+		},
+		"nowAdd": func(duration string) (string, error) {
+			d, err := time.ParseDuration(duration)
+			if err != nil {
+				return "", err
+			}
+			return time.Now().UTC().Add(d).Format(time.RFC3339Nano), nil
+		},
+		"randomID": func(length int) string {
+			return utils.RandomID(length)
+		},
+	}
+}
+
+// sortTablesByDependency orders tables so that the ones referenced via a bun relation
+// (belongs-to, has-one, has-many) come before the tables holding the foreign key.
+// Tables without dependencies between them keep their order.
+func sortTablesByDependency(tables []*schema.Table) ([]*schema.Table, error) {
+	registered := make(map[*schema.Table]bool, len(tables))
+	for _, table := range tables {
+		registered[table] = true
+	}
+
+	dependsOn := make(map[*schema.Table]map[*schema.Table]bool, len(tables))
+	addDependency := func(table, dependency *schema.Table) {
+		if table == dependency || !registered[table] || !registered[dependency] {
+			return
+		}
+		if dependsOn[table] == nil {
+			dependsOn[table] = make(map[*schema.Table]bool)
+		}
+		dependsOn[table][dependency] = true
+	}
+
+	// the side of a relation holding the foreign key depends on the other side. It is deduced from
+	// the join fields, as bun (v1.1.x) swaps the has-one & belongs-to relation types.
+	for _, table := range tables {
+		for _, rel := range table.Relations {
+			if rel.Type == schema.ManyToManyRelation {
+				continue
+			}
+
+			basePK, joinPK := allPKs(rel.BaseFields), allPKs(rel.JoinFields)
+			switch {
+			case joinPK && !basePK:
+				addDependency(table, rel.JoinTable)
+			case basePK && !joinPK:
+				addDependency(rel.JoinTable, table)
+			}
+		}
+	}
+
+	sorted := make([]*schema.Table, 0, len(tables))
+	done := make(map[*schema.Table]bool, len(tables))
+
+	for len(sorted) < len(tables) {
+		progressed := false
+
+		for _, table := range tables {
+			if done[table] {
+				continue
+			}
+
+			ready := true
+			for dependency := range dependsOn[table] {
+				if !done[dependency] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				sorted = append(sorted, table)
+				done[table] = true
+				progressed = true
+			}
+		}
+
+		if !progressed {
+			var names []string
+			for _, table := range tables {
+				if !done[table] {
+					names = append(names, table.Name)
+				}
+			}
+			return nil, fmt.Errorf("circular dependency between tables: %s", strings.Join(names, ", "))
+		}
+	}
+
+	return sorted, nil
+}
+
+func allPKs(fields []*schema.Field) bool {
+	for _, f := range fields {
+		if !f.IsPK {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun/schema"
+)
+
+type Author struct {
+	Id        string `bun:",pk"`
+	Name      string `bun:",notnull"`
+	CreatedAt time.Time
+	Books     []*AuthorBook `bun:"rel:has-many,join:id=author_id"`
+}
+
+type AuthorBook struct {
+	Id       string `bun:",pk"`
+	AuthorId string `bun:",notnull"`
+	Title    string
+	Author   *Author `bun:"rel:belongs-to,join:author_id=id"`
+}
+
+const authorsFixture = `
+author_books:
+  - id: book1
+    author_id: tolkien
+    title: "The Hobbit"
+  - id: "{{ randomID 10 }}"
+    author_id: tolkien
+    title: "The Silmarillion"
+authors:
+  - id: tolkien
+    name: "J. R. R. Tolkien"
+    created_at: "{{ now }}"
+`
+
+func TestFixtures(t *testing.T) {
+	ctx, db, crudRepo := setUp("file:fixtures?mode=memory&cache=shared")
+	if err := crudRepo.Migrate(ctx, (*Author)(nil), (*AuthorBook)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// registered out of order on purpose
+	fixtures, err := NewFixtures(db, (*AuthorBook)(nil), (*Author)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// yaml
+	err = fixtures.LoadBytes(ctx, []byte(authorsFixture))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	author := Author{Id: "tolkien"}
+	err = crudRepo.FindByPK(ctx, &author)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "J. R. R. Tolkien", author.Name)
+	assert.WithinDuration(t, time.Now(), author.CreatedAt, time.Minute)
+
+	var books []AuthorBook
+	err = crudRepo.List(ctx, &books)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 2, len(books), "expected %+v but got: %+v", 2, len(books))
+	assert.Equalf(t, 10, len(books[1].Id), "expected a random id but got: %+v", books[1].Id)
+
+	// reset
+	err = fixtures.Reset(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	books = nil
+	err = crudRepo.List(ctx, &books)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 0, len(books), "expected %+v but got: %+v", 0, len(books))
+
+	// json file
+	file := filepath.Join(t.TempDir(), "authors.json")
+	err = os.WriteFile(file, []byte(`{"authors": [{"id": "orwell", "name": "George Orwell"}]}`), 0o600)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	err = fixtures.Load(ctx, file)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	err = crudRepo.FindByPK(ctx, &Author{Id: "orwell"})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// unknown tables & columns
+	err = fixtures.LoadBytes(ctx, []byte(`dictionaries: [{id: oxford}]`))
+	assert.NotNil(t, err, "expected an unregistered table error")
+
+	err = fixtures.LoadBytes(ctx, []byte(`authors: [{id: someone, nickname: x}]`))
+	assert.NotNil(t, err, "expected an unknown column error")
+}
+
+func Test_sortTablesByDependency(t *testing.T) {
+	_, db, _ := setUp("file:fixtures?mode=memory&cache=shared")
+
+	book := db.Dialect().Tables().Get(reflect.TypeOf(AuthorBook{}))
+	author := db.Dialect().Tables().Get(reflect.TypeOf(Author{}))
+	dictionary := db.Dialect().Tables().Get(reflect.TypeOf(Book{}))
+
+	sorted, err := sortTablesByDependency([]*schema.Table{book, dictionary, author})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, []*schema.Table{dictionary, author, book}, sorted)
+}
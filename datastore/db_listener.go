@@ -0,0 +1,104 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// ErrNotifyUnsupported is returned by the LISTEN/NOTIFY helpers on a database other than postgresql
+var ErrNotifyUnsupported = errors.New("listen/notify is only supported on postgresql (pg driver)")
+
+// Notification is a notification received on a channel
+type Notification = pgdriver.Notification
+
+// Listener receives the postgresql notifications (LISTEN) of its channels on a go channel.
+//
+// It holds ONE dedicated connection outside the pool & reconnects (re-subscribing to its channels)
+// when the connection breaks; a periodic ping detects dead connections. Notifications sent while
+// disconnected are lost, as postgresql doesn't queue them, & notifications are dropped when the buffer
+// is full: consumers needing every change should re-read the state after a reconnect.
+//
+//	ln, err := NewListener(db, 100)
+//	defer ln.Close()
+//	err = ln.Listen(ctx, "orders")
+//	for n := range ln.Notifications() {
+//		fmt.Println(n.Channel, n.Payload)
+//	}
+type Listener struct {
+	ln *pgdriver.Listener
+	ch <-chan Notification
+}
+
+// NewListener returns a listener on a connection of db (as returned by NewDBConnection with DriverPostgresql).
+// bufferSize is the number of notifications buffered before dropping.
+func NewListener(db *bun.DB, bufferSize int) (*Listener, error) {
+	if db.Dialect().Name() != dialect.PG {
+		return nil, ErrNotifyUnsupported
+	}
+	if _, ok := db.Driver().(pgdriver.Driver); !ok {
+		return nil, ErrNotifyUnsupported
+	}
+
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	ln := pgdriver.NewListener(db)
+	return &Listener{ln: ln, ch: ln.Channel(pgdriver.WithChannelSize(bufferSize))}, nil
+}
+
+// Listen subscribes to channels. It can be called at any time, also after receiving started.
+func (l *Listener) Listen(ctx context.Context, channels ...string) error {
+	if err := l.ln.Listen(ctx, channels...); err != nil {
+		return fmt.Errorf("listening to %v: %w", channels, err)
+	}
+	return nil
+}
+
+// Unlisten unsubscribes from channels
+func (l *Listener) Unlisten(ctx context.Context, channels ...string) error {
+	if err := l.ln.Unlisten(ctx, channels...); err != nil {
+		return fmt.Errorf("unlistening from %v: %w", channels, err)
+	}
+	return nil
+}
+
+// Notifications returns the channel delivering the notifications. It is closed by Close.
+func (l *Listener) Notifications() <-chan Notification {
+	return l.ch
+}
+
+// Close closes the connection & the notifications channel
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// Notify sends payload on channel (NOTIFY) with db: a *bun.DB, or a bun.Tx to notify within the
+// transaction. Inside a transaction, the notification is delivered on commit only & discarded on
+// rollback, hence listeners never see uncommitted changes:
+//
+//	repo.Transactional(ctx, func(ctx context.Context, tx bun.Tx) error {
+//		if _, err := repo.NewWithTx(tx).Create(ctx, &order, false); err != nil {
+//			return err
+//		}
+//		return Notify(ctx, tx, "orders", order.Id)
+//	})
+func Notify(ctx context.Context, db bun.IDB, channel, payload string) error {
+	if db.Dialect().Name() != dialect.PG {
+		return ErrNotifyUnsupported
+	}
+
+	_, err := db.NewRaw("SELECT pg_notify(?, ?)", channel, payload).Exec(ctx)
+	return err
+}
+
+// Notify sends payload on channel (NOTIFY), within the transaction of the repository if any (see
+// NewWithTx & the package Notify)
+func (r *DBRepository) Notify(ctx context.Context, channel, payload string) error {
+	return Notify(ctx, r.db, channel, payload)
+}
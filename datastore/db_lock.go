@@ -0,0 +1,295 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/otyang/go-pkg/utils"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// Locker is a distributed lock shared by the replicas of a service.
+//
+// Locks are held by the Locker (not by goroutines) & are not reentrant counters: TryLock on a
+// key already held by the same Locker returns true, confirming (& renewing when leased) the lock,
+// except for CacheLocker (see its doc).
+type Locker interface {
+	// TryLock acquires the lock of key without waiting. It reports whether the lock is held.
+	TryLock(ctx context.Context, key string) (bool, error)
+	// Lock acquires the lock of key, waiting for it until ctx is done.
+	Lock(ctx context.Context, key string) error
+	// Unlock releases the lock of key.
+	Unlock(ctx context.Context, key string) error
+}
+
+var (
+	_ Locker = (*AdvisoryLocker)(nil)
+	_ Locker = (*LeaseLocker)(nil)
+)
+
+// ErrLockNotHeld is returned by Unlock for a lock which isn't held (anymore)
+var ErrLockNotHeld = errors.New("lock not held")
+
+// AdvisoryLocker is a Locker on postgresql session advisory locks. Every held lock pins a connection of
+// the pool, & the lock is released by postgresql as soon as the connection is lost (crash, network).
+type AdvisoryLocker struct {
+	db *bun.DB
+
+	mu    sync.Mutex
+	conns map[string]bun.Conn
+}
+
+// NewAdvisoryLocker returns a Locker on postgresql advisory locks
+func NewAdvisoryLocker(db *bun.DB) (*AdvisoryLocker, error) {
+	if db.Dialect().Name() != dialect.PG {
+		return nil, fmt.Errorf("advisory locks are only supported on postgresql")
+	}
+	return &AdvisoryLocker{db: db, conns: make(map[string]bun.Conn)}, nil
+}
+
+func (l *AdvisoryLocker) TryLock(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// held: check the session (& so the lock) is still alive
+	if conn, ok := l.conns[key]; ok {
+		if err := conn.PingContext(ctx); err != nil {
+			_ = conn.Close()
+			delete(l.conns, key)
+			return false, err
+		}
+		return true, nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+	if err := conn.NewRaw("SELECT pg_try_advisory_lock(?)", lockID(key)).Scan(ctx, &locked); err != nil {
+		_ = conn.Close()
+		return false, err
+	}
+	if !locked {
+		return false, conn.Close()
+	}
+
+	l.conns[key] = conn
+	return true, nil
+}
+
+func (l *AdvisoryLocker) Lock(ctx context.Context, key string) error {
+	return pollLock(ctx, l, key, 100*time.Millisecond)
+}
+
+func (l *AdvisoryLocker) Unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conn, ok := l.conns[key]
+	if !ok {
+		return ErrLockNotHeld
+	}
+	delete(l.conns, key)
+
+	// closing the connection returns it to the pool, with the lock: release it first
+	var unlocked bool
+	err := conn.NewRaw("SELECT pg_advisory_unlock(?)", lockID(key)).Scan(ctx, &unlocked)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !unlocked {
+		err = ErrLockNotHeld
+	}
+	return err
+}
+
+// lockID maps a key to the bigint identifying an advisory lock
+func lockID(key string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+// dbLock is a lease of LeaseLocker. ExpiresAt is in unix nanoseconds, comparable on every database.
+type dbLock struct {
+	bun.BaseModel `bun:"table:datastore_locks"`
+
+	Key       string `bun:",pk"`
+	Owner     string `bun:",notnull"`
+	ExpiresAt int64  `bun:",notnull"`
+}
+
+// LeaseLocker is a Locker on a lease table (datastore_locks), working on every database (eg: sqlite).
+//
+// A lock is a lease expiring after ttl, so that the lock of a crashed replica becomes free again.
+// Work lasting longer than ttl must renew the lease by calling TryLock again before it expires.
+type LeaseLocker struct {
+	db    bun.IDB
+	ttl   time.Duration
+	owner string
+}
+
+// NewLeaseLocker returns a Locker leasing locks for ttl. It creates the lease table if needed.
+func NewLeaseLocker(ctx context.Context, db bun.IDB, ttl time.Duration) (*LeaseLocker, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lease ttl must be positive, got %s", ttl)
+	}
+
+	if _, err := db.NewCreateTable().Model((*dbLock)(nil)).IfNotExists().Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed creating lock table: %w", err)
+	}
+
+	return &LeaseLocker{db: db, ttl: ttl, owner: utils.RandomID(20)}, nil
+}
+
+func (l *LeaseLocker) TryLock(ctx context.Context, key string) (bool, error) {
+	now := time.Now()
+	lock := &dbLock{Key: key, Owner: l.owner, ExpiresAt: now.Add(l.ttl).UnixNano()}
+
+	// acquire a free or expired lease, or renew ours
+	res, err := l.db.NewInsert().Model(lock).
+		On(`CONFLICT ("key") DO UPDATE`).
+		Set("owner = EXCLUDED.owner").
+		Set("expires_at = EXCLUDED.expires_at").
+		Where("?TableAlias.expires_at < ? OR ?TableAlias.owner = ?", now.UnixNano(), l.owner).
+		Exec(ctx)
+
+	n, err := rowsAffected(res, err)
+	return n == 1, err
+}
+
+func (l *LeaseLocker) Lock(ctx context.Context, key string) error {
+	interval := l.ttl / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return pollLock(ctx, l, key, interval)
+}
+
+func (l *LeaseLocker) Unlock(ctx context.Context, key string) error {
+	res, err := l.db.NewDelete().Model((*dbLock)(nil)).
+		Where(`"key" = ?`, key).
+		Where("owner = ?", l.owner).
+		Where("expires_at >= ?", time.Now().UnixNano()).
+		Exec(ctx)
+
+	n, err := rowsAffected(res, err)
+	if err == nil && n == 0 {
+		err = ErrLockNotHeld
+	}
+	return err
+}
+
+func pollLock(ctx context.Context, l Locker, key string, interval time.Duration) error {
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		locked, err := l.TryLock(ctx, key)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// lostNotifier is a Locker telling when its held locks are lost, eg: CacheLocker
+type lostNotifier interface {
+	Lost(key string) <-chan struct{}
+}
+
+// RunLeaderElection makes this replica compete for the leadership of key until ctx is done.
+//
+// Every interval the leadership is acquired or confirmed (renewing a lease: interval must be shorter
+// than the lease ttl). On gaining it, onElected is called in its own goroutine with a context cancelled
+// on losing it; on losing it (including when ctx is done), onRevoked is called. Errors of the locker
+// count as a loss, as the leadership can't be confirmed. Either callback may be nil. The leadership held
+// on a CacheLocker is confirmed by its Lost channel, as the locker extends its locks itself.
+//
+//	go RunLeaderElection(ctx, locker, "cron", 5*time.Second, func(ctx context.Context) {
+//		runCron(ctx) // until ctx is cancelled
+//	}, nil)
+func RunLeaderElection(
+	ctx context.Context, locker Locker, key string, interval time.Duration,
+	onElected func(ctx context.Context), onRevoked func(),
+) {
+	var (
+		leader bool
+		cancel context.CancelFunc = func() {}
+	)
+	defer func() { cancel() }()
+
+	elect := func() context.CancelFunc {
+		leaderCtx, cancel := context.WithCancel(ctx)
+		if onElected != nil {
+			go onElected(leaderCtx)
+		}
+		return cancel
+	}
+
+	revoke := func() {
+		leader = false
+		cancel()
+		if onRevoked != nil {
+			onRevoked()
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	held, _ := locker.(lostNotifier)
+	for {
+		var locked bool
+		if leader && held != nil {
+			select {
+			case <-held.Lost(key):
+			default:
+				locked = true
+			}
+		} else {
+			l, err := locker.TryLock(ctx, key)
+			locked = l && err == nil
+		}
+
+		switch {
+		case locked && !leader:
+			leader = true
+			cancel = elect()
+		case !locked && leader:
+			revoke()
+		}
+
+		select {
+		case <-ctx.Done():
+			if leader {
+				revoke()
+				// ctx is done: release with a fresh context, letting another replica take over now
+				releaseCtx, cancelRelease := context.WithTimeout(context.Background(), interval)
+				_ = locker.Unlock(releaseCtx, key)
+				cancelRelease()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseLocker(t *testing.T) {
+	ctx, db, _ := setUp("file:lock?mode=memory&cache=shared")
+
+	ttl := 200 * time.Millisecond
+	lockerA, err := NewLeaseLocker(ctx, db, ttl)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	lockerB, err := NewLeaseLocker(ctx, db, ttl)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	locked, err := lockerA.TryLock(ctx, "job")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Truef(t, locked, "expected lock to be acquired")
+
+	// held by A: renewable by A only
+	locked, _ = lockerA.TryLock(ctx, "job")
+	assert.Truef(t, locked, "expected lock to be renewed")
+	locked, _ = lockerB.TryLock(ctx, "job")
+	assert.Falsef(t, locked, "expected lock to be held by another locker")
+
+	err = lockerB.Unlock(ctx, "job")
+	assert.Equalf(t, ErrLockNotHeld, err, "expected %+v but got: %+v", ErrLockNotHeld, err)
+
+	// unlocked: free for B
+	err = lockerA.Unlock(ctx, "job")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	err = lockerB.Lock(ctx, "job")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// Lock waits for the lease of B to expire
+	start := time.Now()
+	err = lockerA.Lock(ctx, "job")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Truef(t, time.Since(start) > ttl/2, "expected Lock to wait for the lease to expire")
+
+	// Lock gives up with ctx
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = lockerB.Lock(timeoutCtx, "job")
+	assert.Equalf(t, context.DeadlineExceeded, err, "expected %+v but got: %+v", context.DeadlineExceeded, err)
+}
+
+func TestRunLeaderElection(t *testing.T) {
+	ctx, db, _ := setUp("file:leader?mode=memory&cache=shared")
+
+	var leaders, revoked atomic.Int32
+	candidate := func(ctx context.Context, locker Locker) {
+		RunLeaderElection(ctx, locker, "cron", 20*time.Millisecond, func(ctx context.Context) {
+			leaders.Add(1)
+			<-ctx.Done()
+			leaders.Add(-1)
+		}, func() {
+			revoked.Add(1)
+		})
+	}
+
+	lockerA, err := NewLeaseLocker(ctx, db, time.Second)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	lockerB, err := NewLeaseLocker(ctx, db, time.Second)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	ctxA, stopA := context.WithCancel(ctx)
+	doneA := make(chan struct{})
+	go func() { candidate(ctxA, lockerA); close(doneA) }()
+	time.Sleep(100 * time.Millisecond)
+
+	ctxB, stopB := context.WithCancel(ctx)
+	defer stopB()
+	go candidate(ctxB, lockerB)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equalf(t, int32(1), leaders.Load(), "expected %+v but got: %+v", 1, leaders.Load())
+	assert.Equalf(t, int32(0), revoked.Load(), "expected %+v but got: %+v", 0, revoked.Load())
+
+	// the leader (A) leaves: it is revoked & releases the lock, B takes over without waiting for the lease
+	stopA()
+	<-doneA
+	assert.Equalf(t, int32(1), revoked.Load(), "expected %+v but got: %+v", 1, revoked.Load())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equalf(t, int32(1), leaders.Load(), "expected %+v but got: %+v", 1, leaders.Load())
+}
@@ -0,0 +1,253 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/schema"
+)
+
+// IndexCreator is implemented by models needing indexes the 'index' tag can't express
+// (expressions, GIN, ...). Migrate calls CreateIndexes on the zero model after creating its table,
+// so it must be idempotent (IF NOT EXISTS) & must not use the receiver's fields.
+//
+//	func (*Book) CreateIndexes(ctx context.Context, db bun.IDB) error {
+//		_, err := db.NewCreateIndex().Model((*Book)(nil)).IfNotExists().
+//			Index("books_lower_title_idx").ColumnExpr("lower(title)").Exec(ctx)
+//		return err
+//	}
+type IndexCreator interface {
+	CreateIndexes(ctx context.Context, db bun.IDB) error
+}
+
+// Migrate creates the tables of the models with their foreign keys & indexes. It is idempotent.
+//
+// Tables are created in dependency order. Foreign keys come from the bun relations
+// ('rel:belongs-to' & 'rel:has-one', honouring on_update & on_delete), & may form cycles (A <-> B):
+// on postgresql they are added once the tables exist (ALTER TABLE), to existing tables too. On sqlite
+// they can only be created with their table: the foreign keys of a model missing from an existing
+// table are reported by DetectSchemaDrift (DiffMissingForeignKey), to migrate by hand. Indexes come from the
+// 'index' struct tag: a field joins the index named by the tag, several fields sharing a name
+// make a composite index (in field order), 'unique' makes it unique & 'where:' makes it partial.
+// A field can belong to several indexes separated by ';'.
+//
+//	type Book struct {
+//		Id        string `bun:",pk"`
+//		AuthorId  string `index:"books_author_title_idx,unique"`
+//		Title     string `index:"books_author_title_idx,unique;books_title_idx"`
+//		DeletedAt time.Time `index:"books_live_idx,where:deleted_at IS NULL"`
+//	}
+//
+// Lastly models implementing IndexCreator get to create their own.
+//
+// Usage: Migrate(ctx, (*StructModel1)(nil), (*StructModel2)(nil), .....)
+func (r *DBRepository) Migrate(ctx context.Context, modelsPtr ...any) error {
+	tables := make([]*schema.Table, 0, len(modelsPtr))
+	models := make(map[*schema.Table]any, len(modelsPtr))
+
+	for _, model := range modelsPtr {
+		typ := reflect.TypeOf(model)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return fmt.Errorf("failed creating schema resources: model must be a pointer to a struct, got %T", model)
+		}
+
+		table := r.db.Dialect().Tables().Get(typ)
+		if _, ok := models[table]; !ok {
+			tables = append(tables, table)
+		}
+		models[table] = model
+	}
+
+	// with cycles the order doesn't matter: sqlite doesn't check the referenced tables exist & postgresql
+	// gets the foreign keys once the tables exist
+	if sorted, err := sortTablesByDependency(tables); err == nil {
+		tables = sorted
+	}
+	alterForeignKeys := r.db.Dialect().Name() == dialect.PG
+
+	for _, table := range tables {
+		model := models[table]
+
+		q := r.db.NewCreateTable().Model(model).IfNotExists()
+		if !alterForeignKeys {
+			for _, fk := range modelForeignKeys(table) {
+				q.ForeignKey("(?) REFERENCES ? (?) ? ?",
+					bun.Safe(identList(fk.Columns, "")), bun.Ident(fk.RefTable), bun.Safe(identList(fk.RefColumns, "")),
+					bun.Safe(fk.OnUpdate), bun.Safe(fk.OnDelete),
+				)
+			}
+		}
+		if _, err := q.Exec(ctx); err != nil {
+			return fmt.Errorf("failed creating schema resources: %w", err)
+		}
+
+		for _, idx := range declaredIndexes(table) {
+			if _, err := r.createIndexQuery(table, idx).Exec(ctx); err != nil {
+				return fmt.Errorf("failed creating index %s: %w", idx.Name, err)
+			}
+		}
+
+		if creator, ok := model.(IndexCreator); ok {
+			if err := creator.CreateIndexes(ctx, r.db); err != nil {
+				return fmt.Errorf("failed creating indexes of %s: %w", table.Name, err)
+			}
+		}
+	}
+
+	if alterForeignKeys {
+		for _, table := range tables {
+			if err := r.addForeignKeys(ctx, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addForeignKeys adds the foreign keys of the model of table missing from the table (postgresql)
+func (r *DBRepository) addForeignKeys(ctx context.Context, table *schema.Table) error {
+	live, err := r.tableForeignKeys(ctx, table.Name)
+	if err != nil {
+		return fmt.Errorf("reading foreign keys of %s: %w", table.Name, err)
+	}
+
+	for _, fk := range modelForeignKeys(table) {
+		if hasForeignKey(live, fk) {
+			continue
+		}
+		if _, err := r.db.ExecContext(ctx, r.addForeignKeyQuery(table, fk)); err != nil {
+			return fmt.Errorf("failed creating foreign key %s: %w", fk.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *DBRepository) addForeignKeyQuery(table *schema.Table, fk tableForeignKey) string {
+	return r.formatQuery("ALTER TABLE ? ADD CONSTRAINT ? FOREIGN KEY (?) REFERENCES ? (?) ? ?",
+		table.SQLName, bun.Ident(fk.Name), bun.Safe(identList(fk.Columns, "")),
+		bun.Ident(fk.RefTable), bun.Safe(identList(fk.RefColumns, "")), bun.Safe(fk.OnUpdate), bun.Safe(fk.OnDelete),
+	)
+}
+
+// modelForeignKeys returns the foreign keys of a model: its relations on the primary-keys of another
+// table. They are named like postgresql names them, '<table>_<columns>_fkey'.
+func modelForeignKeys(table *schema.Table) []tableForeignKey {
+	var fks []tableForeignKey
+
+	for _, rel := range table.Relations {
+		if rel.Type == schema.ManyToManyRelation || !allPKs(rel.JoinFields) || allPKs(rel.BaseFields) {
+			continue
+		}
+
+		fk := tableForeignKey{RefTable: rel.JoinTable.Name, OnUpdate: rel.OnUpdate, OnDelete: rel.OnDelete}
+		for _, f := range rel.BaseFields {
+			fk.Columns = append(fk.Columns, f.Name)
+		}
+		for _, f := range rel.JoinFields {
+			fk.RefColumns = append(fk.RefColumns, f.Name)
+		}
+		fk.Name = table.Name + "_" + strings.Join(fk.Columns, "_") + "_fkey"
+		fks = append(fks, fk)
+	}
+
+	return fks
+}
+
+// hasForeignKey reports whether fks has a foreign key on the columns of fk to its table
+func hasForeignKey(fks []tableForeignKey, fk tableForeignKey) bool {
+	for _, live := range fks {
+		if live.RefTable == fk.RefTable && strings.Join(live.Columns, ",") == strings.Join(fk.Columns, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *DBRepository) createIndexQuery(table *schema.Table, idx tableIndex) *bun.CreateIndexQuery {
+	q := r.db.NewCreateIndex().
+		Table(table.Name).
+		Index(idx.Name).
+		Column(idx.Columns...).
+		IfNotExists()
+
+	if idx.Unique {
+		q.Unique()
+	}
+	if idx.Where != "" {
+		q.Where(idx.Where)
+	}
+	return q
+}
+
+// declaredIndexes parses the 'index' struct tags of a model. See Migrate.
+func declaredIndexes(table *schema.Table) []tableIndex {
+	var indexes []tableIndex
+	byName := make(map[string]int)
+
+	for _, f := range table.Fields {
+		tag, ok := f.StructField.Tag.Lookup("index")
+		if !ok {
+			continue
+		}
+
+		for _, def := range strings.Split(tag, ";") {
+			idx := parseIndexTag(table, f, def)
+
+			if i, ok := byName[idx.Name]; ok {
+				indexes[i].Columns = append(indexes[i].Columns, f.Name)
+				indexes[i].Unique = indexes[i].Unique || idx.Unique
+				if indexes[i].Where == "" {
+					indexes[i].Where = idx.Where
+				}
+				continue
+			}
+
+			byName[idx.Name] = len(indexes)
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return indexes
+}
+
+// parseIndexTag parses 'name,unique,where:condition'. The condition comes last as it may contain commas.
+func parseIndexTag(table *schema.Table, f *schema.Field, def string) tableIndex {
+	idx := tableIndex{Columns: []string{f.Name}}
+
+	def = strings.TrimSpace(def)
+	if i := strings.Index(def, "where:"); i >= 0 {
+		idx.Where = strings.TrimSpace(def[i+len("where:"):])
+		def = def[:i]
+	}
+
+	for i, opt := range strings.Split(def, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case i == 0:
+			idx.Name = opt
+		case opt == "unique":
+			idx.Unique = true
+		}
+	}
+
+	if idx.Name == "" {
+		idx.Name = table.Name + "_" + f.Name + "_idx"
+	}
+	return idx
+}
+
+func columnList(fields []*schema.Field) bun.Safe {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = string(f.SQLName)
+	}
+	return bun.Safe(strings.Join(names, ", "))
+}
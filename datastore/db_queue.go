@@ -0,0 +1,374 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// JobStatus is the state of a job in the queue
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending" // waiting for its run_at, or a retry
+	JobRunning JobStatus = "running" // claimed by a worker
+	JobDone    JobStatus = "done"
+	JobDead    JobStatus = "dead" // failed max attempts times: the dead-letter state
+)
+
+func (s JobStatus) String() string {
+	return string(s)
+}
+
+// ErrDuplicateJob is returned by Enqueue for a unique job already pending or running
+var ErrDuplicateJob = errors.New("a job with this unique key is already queued")
+
+// errLeaseExpired is the error of the jobs whose lease expired: timed out, or their worker crashed
+var errLeaseExpired = errors.New("job lease expired: timed out, or its worker crashed")
+
+// jobCompleteTimeout bounds recording the outcome of a job, which mustn't use the (expired) context
+// of the job
+const jobCompleteTimeout = 10 * time.Second
+
+// Job is a job of the queue. Times are unix nanoseconds, comparable on every database.
+type Job struct {
+	bun.BaseModel `bun:"table:datastore_jobs"`
+
+	Id          int64     `bun:",pk,autoincrement"`
+	Type        string    `bun:",notnull"`
+	Payload     string    `bun:",notnull"` // json
+	Status      JobStatus `bun:",notnull" index:"datastore_jobs_claim_idx"`
+	RunAt       int64     `bun:",notnull" index:"datastore_jobs_claim_idx"`
+	Attempts    int       `bun:",notnull"`
+	MaxAttempts int       `bun:",notnull"`
+	LockedUntil int64     `bun:",notnull"`
+	UniqueKey   *string   `bun:",unique"` // cleared once the job is done or dead, so it can be queued again
+	Cron        string    // schedule of a recurring job, see Schedule
+	LastError   string
+	CreatedAt   int64 `bun:",notnull"`
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal([]byte(j.Payload), v)
+}
+
+// JobHandler processes the jobs of a type. A returned error (or panic) schedules a retry.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// EnqueueOption customises an enqueued job
+type EnqueueOption func(job *Job)
+
+// JobUniqueKey makes the job unique: Enqueue fails with ErrDuplicateJob while a job with the same key
+// is pending or running.
+func JobUniqueKey(key string) EnqueueOption {
+	return func(job *Job) {
+		job.UniqueKey = &key
+	}
+}
+
+// JobMaxAttempts overrides the max attempts of the queue for the job
+func JobMaxAttempts(n int) EnqueueOption {
+	return func(job *Job) {
+		job.MaxAttempts = n
+	}
+}
+
+// Queue is a durable job queue stored in the table datastore_jobs.
+//
+// Workers claim jobs (with FOR UPDATE SKIP LOCKED on postgresql, so that any number of replicas can
+// run workers), retry failed jobs with exponential backoff up to max attempts & then move them to the
+// dead state. A claimed job is leased for the job timeout: the job of a crashed worker is retried (or
+// dead) once its lease expires, like a failed job, so handlers must be idempotent.
+//
+// On sqlite, which allows ONE writer, run a single worker.
+type Queue struct {
+	db       bun.IDB
+	handlers map[string]JobHandler
+
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	jobTimeout   time.Duration
+	pollInterval time.Duration
+}
+
+// NewQueue returns a queue with its handlers, ready to Enqueue & Run. It creates the jobs table if needed.
+//
+// Jobs are attempted maxAttempts times, retries waiting backoff, 2*backoff, 4*backoff... capped at
+// 1 hour. A job running longer than jobTimeout is cancelled & retried.
+func NewQueue(
+	ctx context.Context, db bun.IDB, handlers map[string]JobHandler,
+	maxAttempts int, backoff, jobTimeout time.Duration,
+) (*Queue, error) {
+	if maxAttempts < 1 || backoff <= 0 || jobTimeout <= 0 {
+		return nil, fmt.Errorf("invalid queue settings: maxAttempts %d, backoff %s, jobTimeout %s", maxAttempts, backoff, jobTimeout)
+	}
+
+	if err := (&DBRepository{db: db}).Migrate(ctx, (*Job)(nil)); err != nil {
+		return nil, err
+	}
+
+	return &Queue{
+		db:           db,
+		handlers:     handlers,
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		maxBackoff:   time.Hour,
+		jobTimeout:   jobTimeout,
+		pollInterval: time.Second,
+	}, nil
+}
+
+// NewWithTx returns the queue enqueueing within the transaction: the job is only queued if the
+// transaction commits (eg: with the record it processes).
+func (q *Queue) NewWithTx(tx bun.Tx) *Queue {
+	clone := *q
+	clone.db = tx
+	return &clone
+}
+
+// Enqueue queues a job of jobType, to run at runAt (now if zero). payload is marshalled as json.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any, runAt time.Time, opts ...EnqueueOption) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling payload of %s job: %w", jobType, err)
+	}
+
+	now := time.Now()
+	if runAt.IsZero() {
+		runAt = now
+	}
+
+	job := &Job{
+		Type:        jobType,
+		Payload:     string(b),
+		Status:      JobPending,
+		RunAt:       runAt.UnixNano(),
+		MaxAttempts: q.maxAttempts,
+		CreatedAt:   now.UnixNano(),
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	n, err := rowsAffected(q.db.NewInsert().Model(job).Ignore().Returning("*").Exec(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrDuplicateJob
+	}
+	return job, nil
+}
+
+// Schedule makes jobType recurring on a cron schedule (standard 5 fields, eg: "0 3 * * *" or "@hourly").
+// The next occurrence is queued as a unique job, so that replicas scheduling the same job at start
+// don't duplicate it, & every run queues the next one.
+func (q *Queue) Schedule(ctx context.Context, jobType, spec string, payload any) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("parsing schedule of %s job: %w", jobType, err)
+	}
+
+	_, err = q.Enqueue(ctx, jobType, payload, schedule.Next(time.Now()), JobUniqueKey("cron:"+jobType), func(job *Job) {
+		job.Cron = spec
+	})
+	if errors.Is(err, ErrDuplicateJob) {
+		return nil
+	}
+	return err
+}
+
+// RetryDead queues a dead job again, with its attempts reset. It fails with sql.ErrNoRows (see
+// IsErrNotFound) when the job doesn't exist or isn't dead.
+func (q *Queue) RetryDead(ctx context.Context, id int64) error {
+	res, err := q.db.NewUpdate().Model((*Job)(nil)).
+		Set("status = ?", JobPending).
+		Set("attempts = 0").
+		Set("run_at = ?", time.Now().UnixNano()).
+		Where("id = ?", id).
+		Where("status = ?", JobDead).
+		Exec(ctx)
+
+	_, err = mustAffect(true)(rowsAffected(res, err))
+	return err
+}
+
+// Run runs concurrency workers processing the jobs of the handlers until ctx is done. It then stops
+// claiming jobs & waits for the running ones to finish (graceful shutdown) before returning.
+func (q *Queue) Run(ctx context.Context, concurrency int) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err == nil {
+			_ = q.process(job)
+			continue
+		}
+
+		// nothing to do (or the database is unavailable): wait
+		select {
+		case <-ctx.Done():
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// ProcessNext claims & processes ONE due job. It reports whether a job was processed. Useful in tests.
+func (q *Queue) ProcessNext(ctx context.Context) (bool, error) {
+	job, err := q.claim(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, q.process(job)
+}
+
+// claim leases the next due job, once the jobs whose lease expired are retried or dead
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	types := make([]string, 0, len(q.handlers))
+	for t := range q.handlers {
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now().UnixNano()
+	if err := q.expireLeases(ctx, types, now); err != nil {
+		return nil, err
+	}
+
+	next := q.db.NewSelect().Model((*Job)(nil)).
+		Column("id").
+		Where("type IN (?)", bun.In(types)).
+		Where("status = ?", JobPending).
+		Where("run_at <= ?", now).
+		OrderExpr("run_at, id").
+		Limit(1)
+	if q.db.Dialect().Name() == dialect.PG {
+		next.For("UPDATE SKIP LOCKED")
+	}
+
+	job := new(Job)
+	err := q.db.NewUpdate().Model(job).
+		Set("status = ?", JobRunning).
+		Set("attempts = attempts + 1").
+		Set("locked_until = ?", time.Now().Add(q.jobTimeout).UnixNano()).
+		Where("id = (?)", next).
+		Returning("*").
+		Scan(ctx)
+	return job, err
+}
+
+// expireLeases fails the running jobs whose lease expired (timed out, or their worker crashed): they
+// are retried with backoff, or dead after max attempts
+func (q *Queue) expireLeases(ctx context.Context, types []string, now int64) error {
+	var expired []*Job
+	err := q.db.NewSelect().Model(&expired).
+		Where("type IN (?)", bun.In(types)).
+		Where("status = ?", JobRunning).
+		Where("locked_until < ?", now).
+		Limit(100).
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range expired {
+		if err := q.complete(ctx, job, errLeaseExpired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Queue) process(job *Job) error {
+	// not cancelled by the shutdown of Run: running jobs finish
+	ctx, cancel := context.WithTimeout(context.Background(), q.jobTimeout)
+	defer cancel()
+
+	jobErr := q.handle(ctx, job)
+
+	// the job may have used up its context
+	ctx, cancel = context.WithTimeout(context.Background(), jobCompleteTimeout)
+	defer cancel()
+	return q.complete(ctx, job, jobErr)
+}
+
+func (q *Queue) handle(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return q.handlers[job.Type](ctx, job)
+}
+
+// complete records the outcome of a job: done, retried or dead. Recurring jobs queue their next run.
+// Nothing is recorded for a job completed meanwhile (eg: its lease expired).
+func (q *Queue) complete(ctx context.Context, job *Job, jobErr error) error {
+	return q.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		update := tx.NewUpdate().Model((*Job)(nil)).
+			Where("id = ?", job.Id).
+			Where("status = ?", JobRunning).
+			Where("attempts = ?", job.Attempts) // not re-claimed meanwhile
+
+		switch {
+		case jobErr == nil:
+			update.Set("status = ?", JobDone).Set("unique_key = NULL").Set("last_error = ''")
+		case job.Attempts >= job.MaxAttempts:
+			update.Set("status = ?", JobDead).Set("unique_key = NULL").Set("last_error = ?", jobErr.Error())
+		default:
+			update.Set("status = ?", JobPending).
+				Set("run_at = ?", time.Now().Add(q.retryDelay(job.Attempts)).UnixNano()).
+				Set("last_error = ?", jobErr.Error())
+		}
+
+		n, err := rowsAffected(update.Exec(ctx))
+		if err != nil || n == 0 {
+			return err
+		}
+
+		if job.Cron == "" || (jobErr != nil && job.Attempts < job.MaxAttempts) {
+			return nil
+		}
+		return q.NewWithTx(tx).Schedule(ctx, job.Type, job.Cron, json.RawMessage(job.Payload))
+	})
+}
+
+// retryDelay is the exponential backoff after attempt
+func (q *Queue) retryDelay(attempt int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempt && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+	return delay
+}
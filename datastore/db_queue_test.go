@@ -0,0 +1,239 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func setUpQueue(t *testing.T, dsn string, handlers map[string]JobHandler) (context.Context, *bun.DB, *Queue) {
+	ctx, db, _ := setUp(dsn)
+
+	queue, err := NewQueue(ctx, db, handlers, 2, 10*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return ctx, db, queue
+}
+
+func findJob(t *testing.T, ctx context.Context, db *bun.DB, id int64) Job {
+	job := Job{Id: id}
+	if err := db.NewSelect().Model(&job).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	return job
+}
+
+func TestQueue_Enqueue_Retry_And_DeadLetter(t *testing.T) {
+	type email struct{ To string }
+
+	var received []string
+	fail := true
+	ctx, db, queue := setUpQueue(t, "file:queue?mode=memory&cache=shared", map[string]JobHandler{
+		"email": func(ctx context.Context, job *Job) error {
+			var e email
+			if err := job.Decode(&e); err != nil {
+				return err
+			}
+			received = append(received, e.To)
+			return nil
+		},
+		"flaky": func(ctx context.Context, job *Job) error {
+			if fail {
+				return errors.New("unavailable")
+			}
+			return nil
+		},
+		"broken": func(ctx context.Context, job *Job) error {
+			panic("broken")
+		},
+	})
+
+	// success
+	job, err := queue.Enqueue(ctx, "email", email{To: "a@b.c"}, time.Time{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	processed, err := queue.ProcessNext(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Truef(t, processed, "expected a job to be processed")
+	assert.Equal(t, []string{"a@b.c"}, received)
+	assert.Equal(t, JobDone, findJob(t, ctx, db, job.Id).Status)
+
+	// nothing due: scheduled later
+	_, err = queue.Enqueue(ctx, "email", email{To: "later"}, time.Now().Add(time.Hour))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	processed, _ = queue.ProcessNext(ctx)
+	assert.Falsef(t, processed, "expected no due job")
+
+	// retried with backoff, then succeeds
+	job, _ = queue.Enqueue(ctx, "flaky", nil, time.Time{})
+	processed, err = queue.ProcessNext(ctx)
+	assert.Truef(t, processed, "expected a job to be processed")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	retried := findJob(t, ctx, db, job.Id)
+	assert.Equal(t, JobPending, retried.Status)
+	assert.Equal(t, 1, retried.Attempts)
+	assert.Equal(t, "unavailable", retried.LastError)
+
+	processed, _ = queue.ProcessNext(ctx)
+	assert.Falsef(t, processed, "expected the retry to wait for the backoff")
+
+	fail = false
+	time.Sleep(20 * time.Millisecond)
+	processed, _ = queue.ProcessNext(ctx)
+	assert.Truef(t, processed, "expected the retry to be processed")
+	assert.Equal(t, JobDone, findJob(t, ctx, db, job.Id).Status)
+
+	// dead after max attempts (panics count as failures), & retried by hand
+	job, _ = queue.Enqueue(ctx, "broken", nil, time.Time{}, JobMaxAttempts(1))
+	_, _ = queue.ProcessNext(ctx)
+	dead := findJob(t, ctx, db, job.Id)
+	assert.Equal(t, JobDead, dead.Status)
+	assert.Equal(t, "panic: broken", dead.LastError)
+
+	err = queue.RetryDead(ctx, job.Id)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, JobPending, findJob(t, ctx, db, job.Id).Status)
+
+	// not dead anymore, or unknown
+	err = queue.RetryDead(ctx, job.Id)
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+	err = queue.RetryDead(ctx, -1)
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+}
+
+func TestQueue_UniqueJobs_And_Schedule(t *testing.T) {
+	var runs int
+	ctx, db, queue := setUpQueue(t, "file:queue_unique?mode=memory&cache=shared", map[string]JobHandler{
+		"report": func(ctx context.Context, job *Job) error {
+			runs++
+			return nil
+		},
+	})
+
+	_, err := queue.Enqueue(ctx, "report", nil, time.Time{}, JobUniqueKey("daily"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	_, err = queue.Enqueue(ctx, "report", nil, time.Time{}, JobUniqueKey("daily"))
+	assert.Equalf(t, ErrDuplicateJob, err, "expected %+v but got: %+v", ErrDuplicateJob, err)
+
+	// once done, the key is free again
+	_, _ = queue.ProcessNext(ctx)
+	_, err = queue.Enqueue(ctx, "report", nil, time.Now().Add(time.Hour), JobUniqueKey("daily"))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// scheduled by every replica: queued once
+	for i := 0; i < 2; i++ {
+		err = queue.Schedule(ctx, "report", "@hourly", nil)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+
+	var scheduled []Job
+	err = db.NewSelect().Model(&scheduled).Where("cron = ?", "@hourly").Scan(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 1, len(scheduled), "expected %+v but got: %+v", 1, len(scheduled))
+
+	// running it queues the next occurrence
+	_, err = db.NewUpdate().Model((*Job)(nil)).Set("run_at = 0").Where("id = ?", scheduled[0].Id).Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	_, _ = queue.ProcessNext(ctx)
+	assert.Equal(t, 2, runs)
+
+	count, err := db.NewSelect().Model((*Job)(nil)).Where("cron = ?", "@hourly").Where("status = ?", JobPending).Count(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 1, count, "expected %+v but got: %+v", 1, count)
+
+	_, err = queue.Enqueue(ctx, "report", nil, time.Time{}, JobUniqueKey("cron:report"))
+	assert.Equalf(t, ErrDuplicateJob, err, "expected %+v but got: %+v", ErrDuplicateJob, err)
+}
+
+func TestQueue_Run_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	ctx, db, queue := setUpQueue(t, "file:queue_run?mode=memory&cache=shared", map[string]JobHandler{
+		"slow": func(ctx context.Context, job *Job) error {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		},
+	})
+
+	job, err := queue.Enqueue(ctx, "slow", nil, time.Time{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		queue.Run(runCtx, 1)
+		close(done)
+	}()
+
+	// stopped while the job runs: Run waits for it
+	<-started
+	stop()
+	<-done
+	assert.Equal(t, JobDone, findJob(t, ctx, db, job.Id).Status)
+}
+
+func TestQueue_ExpiredLease(t *testing.T) {
+	var runs int
+	ctx, db, queue := setUpQueue(t, "file:queue_lease?mode=memory&cache=shared", map[string]JobHandler{
+		"crash": func(ctx context.Context, job *Job) error {
+			runs++
+			return nil
+		},
+	})
+
+	// a worker crashed running them: leased, never completed
+	retried, _ := queue.Enqueue(ctx, "crash", nil, time.Time{})
+	dead, _ := queue.Enqueue(ctx, "crash", nil, time.Time{}, JobMaxAttempts(1))
+	for i := 0; i < 2; i++ {
+		_, err := queue.claim(ctx)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+	_, err := db.NewUpdate().Model((*Job)(nil)).Set("locked_until = 0").Where("status = ?", JobRunning).Exec(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// retried with backoff, or dead after max attempts: not re-leased right away
+	processed, err := queue.ProcessNext(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Falsef(t, processed, "expected the retry to wait for the backoff")
+	assert.Equal(t, 0, runs)
+
+	job := findJob(t, ctx, db, retried.Id)
+	assert.Equal(t, JobPending, job.Status)
+	assert.Equal(t, errLeaseExpired.Error(), job.LastError)
+
+	job = findJob(t, ctx, db, dead.Id)
+	assert.Equal(t, JobDead, job.Status)
+	assert.Equal(t, errLeaseExpired.Error(), job.LastError)
+
+	time.Sleep(20 * time.Millisecond)
+	processed, _ = queue.ProcessNext(ctx)
+	assert.Truef(t, processed, "expected the retry to be processed")
+	assert.Equal(t, JobDone, findJob(t, ctx, db, retried.Id).Status)
+}
+
+func TestQueue_JobTimeout(t *testing.T) {
+	ctx, db, _ := setUp("file:queue_timeout?mode=memory&cache=shared")
+	queue, err := NewQueue(ctx, db, map[string]JobHandler{
+		"slow": func(ctx context.Context, job *Job) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, 2, 10*time.Millisecond, 20*time.Millisecond)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// recorded despite the expired context of the job
+	job, _ := queue.Enqueue(ctx, "slow", nil, time.Time{})
+	_, err = queue.ProcessNext(ctx)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	timedOut := findJob(t, ctx, db, job.Id)
+	assert.Equal(t, JobPending, timedOut.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), timedOut.LastError)
+}
@@ -2,7 +2,7 @@ package datastore
 
 import (
 	"context"
-	"errors"
+	"database/sql"
 
 	"github.com/uptrace/bun"
 )
@@ -15,44 +15,40 @@ type (
 )
 
 type DBRepository struct {
-	db bun.IDB
+	db           bun.IDB
+	tenantColumn string
 }
 
 func NewDBRepository(db *bun.DB) *DBRepository {
 	return &DBRepository{db: db}
 }
 
-// Usage: Migrate(ctx, (*StructModel1)(nil), (*StructModel2)(nil), .....)
-func (r *DBRepository) Migrate(ctx context.Context, modelsPtr ...any) error {
-	for _, model := range modelsPtr {
-		if _, err := r.db.NewCreateTable().Model(model).IfNotExists().Exec(ctx); err != nil {
-			errMsg := "failed creating schema resources: " + err.Error()
-			return errors.New(errMsg)
-		}
+func (r *DBRepository) Create(ctx context.Context, model any, ignoreDupicates bool) (int64, error) {
+	q := r.db.NewInsert().Model(model)
+	if err := r.scopeInsert(ctx, q, model, false); err != nil {
+		return 0, err
 	}
-	return nil
-}
 
-func (r *DBRepository) Create(ctx context.Context, model any, ignoreDupicates bool) error {
 	if ignoreDupicates {
-		_, err := r.db.NewInsert().Model(model).Ignore().Returning("*").Exec(ctx)
-		return err
+		q.Ignore()
 	}
-	_, err := r.db.NewInsert().Model(model).Returning("*").Exec(ctx)
-	return err
-}
-
-func (r *DBRepository) Upsert(ctx context.Context, modelsPtr any) error {
-	_, err := r.db.NewInsert().Model(modelsPtr).On("CONFLICT DO UPDATE").Exec(ctx)
-	return err
+	return rowsAffected(q.Returning("*").Exec(ctx))
 }
 
 func (r *DBRepository) FindByPK(ctx context.Context, modelPtr any) error {
-	return r.db.NewSelect().Model(modelPtr).WherePK().Limit(1).Scan(ctx)
+	q := r.db.NewSelect().Model(modelPtr).WherePK()
+	if err := r.scopeSelect(ctx, q); err != nil {
+		return err
+	}
+
+	return q.Limit(1).Scan(ctx)
 }
 
 func (r *DBRepository) FindWhere(ctx context.Context, modelPtr any, sc ...SelectCriteria) error {
 	q := r.db.NewSelect().Model(modelPtr)
+	if err := r.scopeSelect(ctx, q); err != nil {
+		return err
+	}
 
 	for i := range sc {
 		q.Apply(sc[i])
@@ -63,6 +59,9 @@ func (r *DBRepository) FindWhere(ctx context.Context, modelPtr any, sc ...Select
 
 func (r *DBRepository) List(ctx context.Context, modelPtr any, sc ...SelectCriteria) error {
 	q := r.db.NewSelect().Model(modelPtr)
+	if err := r.scopeSelect(ctx, q); err != nil {
+		return err
+	}
 
 	for i := range sc {
 		q.Apply(sc[i])
@@ -71,37 +70,74 @@ func (r *DBRepository) List(ctx context.Context, modelPtr any, sc ...SelectCrite
 	return q.Scan(ctx)
 }
 
-func (r *DBRepository) Update(ctx context.Context, modelPtr any) error {
-	_, err := r.db.NewUpdate().Model(modelPtr).WherePK().Returning("*").Exec(ctx)
-	return err
+// Update updates a record by primary-key. When mustExist is true & no row matched
+// it fails with sql.ErrNoRows (see IsErrNotFound).
+func (r *DBRepository) Update(ctx context.Context, modelPtr any, mustExist bool) (int64, error) {
+	q := r.db.NewUpdate().Model(modelPtr).WherePK()
+	if err := r.scopeUpdate(ctx, q, modelPtr); err != nil {
+		return 0, err
+	}
+
+	return mustAffect(mustExist)(rowsAffected(q.Returning("*").Exec(ctx)))
 }
 
-func (r *DBRepository) UpdateBulk(ctx context.Context, modelPtr any) error {
-	_, err := r.db.NewUpdate().Model(modelPtr).WherePK().Bulk().Returning("*").Exec(ctx)
-	return err
+func (r *DBRepository) UpdateBulk(ctx context.Context, modelPtr any) (int64, error) {
+	q := r.db.NewUpdate().Model(modelPtr).WherePK().Bulk()
+	if err := r.scopeUpdate(ctx, q, modelPtr); err != nil {
+		return 0, err
+	}
+
+	return rowsAffected(q.Returning("*").Exec(ctx))
 }
 
-func (r *DBRepository) DeleteByPK(ctx context.Context, modelPtr any) error {
-	_, err := r.db.NewDelete().Model(modelPtr).WherePK().Exec(ctx)
-	return err
+// DeleteByPK deletes record(s) by primary-key. When mustExist is true & no row matched
+// it fails with sql.ErrNoRows (see IsErrNotFound).
+func (r *DBRepository) DeleteByPK(ctx context.Context, modelPtr any, mustExist bool) (int64, error) {
+	q := r.db.NewDelete().Model(modelPtr).WherePK()
+	if err := r.scopeDelete(ctx, q); err != nil {
+		return 0, err
+	}
+
+	return mustAffect(mustExist)(rowsAffected(q.Exec(ctx)))
 }
 
-func (r *DBRepository) DeleteWhere(ctx context.Context, modelPtr any, dc ...DeleteCriteria) error {
+func (r *DBRepository) DeleteWhere(ctx context.Context, modelPtr any, dc ...DeleteCriteria) (int64, error) {
 	q := r.db.NewDelete().Model(modelPtr)
+	if err := r.scopeDelete(ctx, q); err != nil {
+		return 0, err
+	}
 
 	for i := range dc {
 		q.Apply(dc[i])
 	}
 
-	return q.Scan(ctx)
+	return rowsAffected(q.Exec(ctx))
+}
+
+// rowsAffected returns the number of rows affected by an executed query
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// mustAffect turns a write that affected no row into sql.ErrNoRows when required
+func mustAffect(required bool) func(n int64, err error) (int64, error) {
+	return func(n int64, err error) (int64, error) {
+		if err == nil && required && n == 0 {
+			return 0, sql.ErrNoRows
+		}
+		return n, err
+	}
 }
 
 // NewWithTx returns a clone of Repository, HOWEVER OVERRIDING the dbConnection with a db-Transaction conn
 // as the new dbConnection
 func (r *DBRepository) NewWithTx(tx bun.Tx) IDBRepository {
-	return &DBRepository{
-		db: tx,
-	}
+	clone := *r
+	clone.db = tx
+	return &clone
 }
 
 // Transactional simplifies transactions code, by automatically:
@@ -67,7 +67,7 @@ func TestDBRepository_Create_Find_FindWhere_And_List(t *testing.T) {
 		{Id: "book2", Title: "hello world"},
 	}
 
-	err = crudRepo.Create(ctx, &seedBooks, true)
+	_, err = crudRepo.Create(ctx, &seedBooks, true)
 	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
 
 	// Find
@@ -102,14 +102,14 @@ func TestDBRepository_Update(t *testing.T) {
 	seedBooks := []Book{
 		{Id: "book1", Title: "hello"},
 	}
-	if err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
+	if _, err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
 	// update
 	want := Book{Id: "book1", Title: "hello ==--updated--=="}
 
-	if err := crudRepo.Update(ctx, &want); err != nil {
+	if _, err := crudRepo.Update(ctx, &want, true); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
@@ -126,7 +126,7 @@ func TestDBRepository_Update(t *testing.T) {
 		{Id: "book2", Title: "hello world --updated--"},
 	}
 
-	if err := crudRepo.UpdateBulk(ctx, &updatedBooks); err != nil {
+	if _, err := crudRepo.UpdateBulk(ctx, &updatedBooks); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
@@ -147,7 +147,7 @@ func TestDBRepository_Upsert(t *testing.T) {
 	seedBooks := []Book{
 		{Id: "book1", Title: "hello"},
 	}
-	if err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
+	if _, err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
@@ -157,7 +157,7 @@ func TestDBRepository_Upsert(t *testing.T) {
 		{Id: "book2", Title: "hello world"},
 	}
 
-	if err := crudRepo.Upsert(ctx, &upsertedBooks); err != nil {
+	if _, err := crudRepo.Upsert(ctx, &upsertedBooks, UpsertOptions{}); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
@@ -181,19 +181,19 @@ func TestDBRepository_Delete_And_DeleteWhere(t *testing.T) {
 		{Id: "book4", Title: "hello world"},
 	}
 
-	if err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
+	if _, err := crudRepo.Create(ctx, &seedBooks, true); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 
 	// DeleteByPK One
-	if err := crudRepo.DeleteByPK(ctx, &[]Book{{Id: "book1"}}); err != nil {
+	if _, err := crudRepo.DeleteByPK(ctx, &[]Book{{Id: "book1"}}, false); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 	err = crudRepo.FindByPK(ctx, &Book{Id: "book1"})
 	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
 
 	// DeleteByPK Multi
-	if err := crudRepo.DeleteByPK(ctx, &[]Book{{Id: "book2"}, {Id: "book3"}}); err != nil {
+	if _, err := crudRepo.DeleteByPK(ctx, &[]Book{{Id: "book2"}, {Id: "book3"}}, false); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 	err = crudRepo.FindByPK(ctx, &Book{Id: "book2"})
@@ -206,7 +206,7 @@ func TestDBRepository_Delete_And_DeleteWhere(t *testing.T) {
 		return q.Where("id = ?", "book1")
 	}
 
-	if err := crudRepo.DeleteWhere(ctx, &[]Book{{Id: "book1"}}, addWhereDelete); err != nil {
+	if _, err := crudRepo.DeleteWhere(ctx, &[]Book{{Id: "book1"}}, addWhereDelete); err != nil {
 		t.Errorf("expected %+v but got: %+v", nil, err)
 	}
 	err = crudRepo.FindByPK(ctx, &Book{Id: "book1"})
@@ -244,8 +244,53 @@ func TestDBRepository_Transactional(t *testing.T) {
 				return err
 			}
 
-			return crudRepo.NewWithTx(tx).Create(ctx, &seedBooks, true)
+			_, err = crudRepo.NewWithTx(tx).Create(ctx, &seedBooks, true)
+			return err
 		},
 	)
 	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
 }
+
+func TestDBRepository_RowsAffected_And_MustExist(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:affected?mode=memory&cache=shared")
+	if err := crudRepo.Migrate(ctx, (*Book)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	seedBooks := []Book{
+		{Id: "book1", Title: "hello"},
+		{Id: "book2", Title: "hello world"},
+		{Id: "book3", Title: "hello world"},
+	}
+	n, err := crudRepo.Create(ctx, &seedBooks, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(3), n, "expected %+v but got: %+v", 3, n)
+
+	// update
+	n, err = crudRepo.Update(ctx, &Book{Id: "book1", Title: "updated"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(1), n, "expected %+v but got: %+v", 1, n)
+
+	n, err = crudRepo.Update(ctx, &Book{Id: "missing", Title: "updated"}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(0), n, "expected %+v but got: %+v", 0, n)
+
+	_, err = crudRepo.Update(ctx, &Book{Id: "missing", Title: "updated"}, true)
+	assert.Truef(t, IsErrNotFound(err), "expected not found but got: %+v", err)
+
+	// delete where
+	addWhereDelete := func(q *bun.DeleteQuery) *bun.DeleteQuery {
+		return q.Where("title = ?", "hello world")
+	}
+	n, err = crudRepo.DeleteWhere(ctx, (*Book)(nil), addWhereDelete)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(2), n, "expected %+v but got: %+v", 2, n)
+
+	// delete by pk
+	_, err = crudRepo.DeleteByPK(ctx, &Book{Id: "book2"}, true)
+	assert.Truef(t, IsErrNotFound(err), "expected not found but got: %+v", err)
+
+	n, err = crudRepo.DeleteByPK(ctx, &Book{Id: "book1"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(1), n, "expected %+v but got: %+v", 1, n)
+}
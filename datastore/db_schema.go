@@ -0,0 +1,484 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/schema"
+)
+
+// SchemaDiffKind is the kind of difference found between a model & the live schema
+type SchemaDiffKind string
+
+const (
+	DiffMissingTable  SchemaDiffKind = "missing_table"
+	DiffMissingColumn SchemaDiffKind = "missing_column"
+	DiffExtraColumn   SchemaDiffKind = "extra_column"
+	DiffColumnType    SchemaDiffKind = "column_type"
+	DiffNullability   SchemaDiffKind = "nullability"
+	DiffMissingIndex  SchemaDiffKind = "missing_index"
+	DiffExtraIndex    SchemaDiffKind = "extra_index"
+
+	DiffMissingForeignKey SchemaDiffKind = "missing_foreign_key"
+)
+
+func (k SchemaDiffKind) String() string {
+	return string(k)
+}
+
+// SchemaDiff is ONE difference between a model & the live schema.
+// Model & Live hold what is expected by the model & what is found in the database.
+// Fix is a draft statement reconciling the database with the model (commented out when destructive
+// or not supported by the database).
+type SchemaDiff struct {
+	Table  string
+	Kind   SchemaDiffKind
+	Column string
+	Model  string
+	Live   string
+	Fix    string
+}
+
+func (d SchemaDiff) String() string {
+	return fmt.Sprintf("%s: %s %s (model: %q, live: %q)", d.Table, d.Kind, d.Column, d.Model, d.Live)
+}
+
+// SchemaDrift lists the differences between models & the live schema
+type SchemaDrift []SchemaDiff
+
+// HasDrift reports whether models & database diverge. Useful to fail CI:
+//
+//	drift, err := repo.DetectSchemaDrift(ctx, (*Book)(nil))
+//	if err != nil || drift.HasDrift() {
+//		log.Fatal(drift.DraftMigration())
+//	}
+func (d SchemaDrift) HasDrift() bool {
+	return len(d) > 0
+}
+
+// DraftMigration returns the statements reconciling the database with the models, as a
+// draft migration to review. Destructive statements are commented out.
+func (d SchemaDrift) DraftMigration() string {
+	var b strings.Builder
+	for _, diff := range d {
+		b.WriteString("-- " + diff.String() + "\n")
+		b.WriteString(diff.Fix + "\n")
+	}
+	return b.String()
+}
+
+// liveColumn is a column read from the database
+type liveColumn struct {
+	Name    string
+	Type    string
+	NotNull bool
+}
+
+// tableIndex is an index read from the database or declared by a model
+type tableIndex struct {
+	Name    string
+	Unique  bool
+	Primary bool
+	Columns []string
+	Where   string
+}
+
+// tableForeignKey is a foreign key read from the database or declared by a model (see Migrate)
+type tableForeignKey struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnUpdate   string
+	OnDelete   string
+}
+
+// DetectSchemaDrift compares the models (as passed to Migrate) with the live schema: tables, columns,
+// types, nullability, unique indexes & foreign keys. It works on postgresql (current schema) & sqlite.
+//
+// Usage: DetectSchemaDrift(ctx, (*StructModel1)(nil), (*StructModel2)(nil), .....)
+func (r *DBRepository) DetectSchemaDrift(ctx context.Context, modelsPtr ...any) (SchemaDrift, error) {
+	var drift SchemaDrift
+
+	for _, model := range modelsPtr {
+		typ := reflect.TypeOf(model)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("model must be a pointer to a struct, got %T", model)
+		}
+		table := r.db.Dialect().Tables().Get(typ)
+
+		columns, err := r.liveColumns(ctx, table.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reading columns of %s: %w", table.Name, err)
+		}
+
+		if len(columns) == 0 {
+			b, err := r.db.NewCreateTable().Model(model).AppendQuery(schema.NewFormatter(r.db.Dialect()), nil)
+			if err != nil {
+				return nil, err
+			}
+			drift = append(drift, SchemaDiff{Table: table.Name, Kind: DiffMissingTable, Fix: string(b) + ";"})
+			continue
+		}
+
+		indexes, err := r.tableIndexes(ctx, table.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reading indexes of %s: %w", table.Name, err)
+		}
+
+		indexesDrift, err := r.indexesDrift(table, modelIndexes(table), indexes)
+		if err != nil {
+			return nil, err
+		}
+
+		foreignKeys, err := r.tableForeignKeys(ctx, table.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reading foreign keys of %s: %w", table.Name, err)
+		}
+
+		drift = append(drift, r.columnsDrift(table, columns)...)
+		drift = append(drift, indexesDrift...)
+		drift = append(drift, r.foreignKeysDrift(table, foreignKeys)...)
+	}
+
+	return drift, nil
+}
+
+func (r *DBRepository) columnsDrift(table *schema.Table, columns []liveColumn) SchemaDrift {
+	var drift SchemaDrift
+	isPG := r.db.Dialect().Name() == dialect.PG
+
+	live := make(map[string]liveColumn, len(columns))
+	for _, c := range columns {
+		live[c.Name] = c
+	}
+
+	for _, f := range table.Fields {
+		c, ok := live[f.Name]
+		if !ok {
+			def := f.CreateTableSQLType
+			if f.SQLDefault != "" {
+				def += " DEFAULT " + f.SQLDefault
+			}
+			if f.NotNull {
+				def += " NOT NULL"
+			}
+
+			drift = append(drift, SchemaDiff{
+				Table: table.Name, Kind: DiffMissingColumn, Column: f.Name, Model: def,
+				Fix: r.formatQuery("ALTER TABLE ? ADD COLUMN ? "+def+";", table.SQLName, f.SQLName),
+			})
+			continue
+		}
+
+		modelType, liveType := normalizeSQLType(f.CreateTableSQLType), normalizeSQLType(c.Type)
+		if modelType != liveType {
+			fix := "-- sqlite can't alter a column type, the table has to be rebuilt"
+			if isPG {
+				fix = r.formatQuery("ALTER TABLE ? ALTER COLUMN ? TYPE "+f.CreateTableSQLType+";", table.SQLName, f.SQLName)
+			}
+			drift = append(drift, SchemaDiff{
+				Table: table.Name, Kind: DiffColumnType, Column: f.Name, Model: modelType, Live: liveType, Fix: fix,
+			})
+		}
+
+		notNull := f.NotNull || f.IsPK
+		if notNull != c.NotNull {
+			fix := "-- sqlite can't alter a column nullability, the table has to be rebuilt"
+			if isPG {
+				action := "DROP NOT NULL"
+				if notNull {
+					action = "SET NOT NULL"
+				}
+				fix = r.formatQuery("ALTER TABLE ? ALTER COLUMN ? "+action+";", table.SQLName, f.SQLName)
+			}
+			drift = append(drift, SchemaDiff{
+				Table: table.Name, Kind: DiffNullability, Column: f.Name, Model: nullability(notNull), Live: nullability(c.NotNull), Fix: fix,
+			})
+		}
+	}
+
+	for _, c := range columns {
+		if _, ok := table.FieldMap[c.Name]; !ok {
+			drift = append(drift, SchemaDiff{
+				Table: table.Name, Kind: DiffExtraColumn, Column: c.Name, Live: c.Type,
+				Fix: "-- " + r.formatQuery("ALTER TABLE ? DROP COLUMN ?;", table.SQLName, bun.Ident(c.Name)),
+			})
+		}
+	}
+
+	return drift
+}
+
+func (r *DBRepository) indexesDrift(table *schema.Table, expected []tableIndex, indexes []tableIndex) (SchemaDrift, error) {
+	var drift SchemaDrift
+
+	found := func(list []tableIndex, want tableIndex) bool {
+		for _, idx := range list {
+			if idx.Unique == want.Unique && strings.Join(idx.Columns, ",") == strings.Join(want.Columns, ",") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, idx := range expected {
+		if found(indexes, idx) {
+			continue
+		}
+
+		b, err := r.createIndexQuery(table, idx).AppendQuery(schema.NewFormatter(r.db.Dialect()), nil)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, SchemaDiff{
+			Table: table.Name, Kind: DiffMissingIndex, Column: strings.Join(idx.Columns, ","), Model: idx.Name,
+			Fix: string(b) + ";",
+		})
+	}
+
+	for _, idx := range indexes {
+		if idx.Primary || !idx.Unique || found(expected, idx) {
+			continue
+		}
+		drift = append(drift, SchemaDiff{
+			Table: table.Name, Kind: DiffExtraIndex, Column: strings.Join(idx.Columns, ","), Live: idx.Name,
+			Fix: "-- " + r.formatQuery("DROP INDEX ?;", bun.Ident(idx.Name)),
+		})
+	}
+
+	return drift, nil
+}
+
+// foreignKeysDrift reports the foreign keys of the model missing from the table. sqlite can't add
+// them to an existing table: the fix is commented out (the table must be recreated).
+func (r *DBRepository) foreignKeysDrift(table *schema.Table, foreignKeys []tableForeignKey) SchemaDrift {
+	var drift SchemaDrift
+
+	for _, fk := range modelForeignKeys(table) {
+		if hasForeignKey(foreignKeys, fk) {
+			continue
+		}
+
+		fix := r.addForeignKeyQuery(table, fk) + ";"
+		if r.db.Dialect().Name() != dialect.PG {
+			fix = "-- " + fix
+		}
+		drift = append(drift, SchemaDiff{
+			Table: table.Name, Kind: DiffMissingForeignKey, Column: strings.Join(fk.Columns, ","),
+			Model: fk.RefTable + "(" + strings.Join(fk.RefColumns, ",") + ")", Fix: fix,
+		})
+	}
+
+	return drift
+}
+
+// modelIndexes returns the indexes expected by a model: bun 'unique' & 'unique:group' tags
+// plus the 'index' tags created by Migrate
+func modelIndexes(table *schema.Table) []tableIndex {
+	var indexes []tableIndex
+	groups := make(map[string]int)
+
+	for _, f := range table.Fields {
+		values, ok := f.Tag.Options["unique"]
+		if !ok {
+			continue
+		}
+
+		group := ""
+		if len(values) > 0 {
+			group = values[0]
+		}
+		if group == "" {
+			indexes = append(indexes, tableIndex{Name: table.Name + "_" + f.Name + "_key", Unique: true, Columns: []string{f.Name}})
+			continue
+		}
+
+		if i, ok := groups[group]; ok {
+			indexes[i].Columns = append(indexes[i].Columns, f.Name)
+			continue
+		}
+		groups[group] = len(indexes)
+		indexes = append(indexes, tableIndex{Name: table.Name + "_" + group + "_key", Unique: true, Columns: []string{f.Name}})
+	}
+
+	return append(indexes, declaredIndexes(table)...)
+}
+
+func (r *DBRepository) liveColumns(ctx context.Context, table string) ([]liveColumn, error) {
+	var columns []liveColumn
+
+	switch r.db.Dialect().Name() {
+	case dialect.SQLite:
+		err := r.db.NewRaw(`SELECT name, type, "notnull" AS not_null FROM pragma_table_info(?) ORDER BY cid`, table).
+			Scan(ctx, &columns)
+		return columns, err
+
+	case dialect.PG:
+		err := r.db.NewRaw(`
+			SELECT column_name AS name,
+				CASE WHEN data_type = 'ARRAY' THEN substr(udt_name, 2) || '[]' ELSE udt_name END AS type,
+				is_nullable = 'NO' AS not_null
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ?
+			ORDER BY ordinal_position`, table).
+			Scan(ctx, &columns)
+		return columns, err
+	}
+
+	return nil, fmt.Errorf("schema drift isn't supported on %s", r.db.Dialect().Name())
+}
+
+func (r *DBRepository) tableIndexes(ctx context.Context, table string) ([]tableIndex, error) {
+	switch r.db.Dialect().Name() {
+	case dialect.SQLite:
+		var list []struct {
+			Name   string
+			Unique bool
+			Origin string
+		}
+		err := r.db.NewRaw(`SELECT name, "unique", origin FROM pragma_index_list(?)`, table).Scan(ctx, &list)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes := make([]tableIndex, 0, len(list))
+		for _, l := range list {
+			idx := tableIndex{Name: l.Name, Unique: l.Unique, Primary: l.Origin == "pk"}
+			err := r.db.NewRaw(`SELECT name FROM pragma_index_info(?) ORDER BY seqno`, l.Name).Scan(ctx, &idx.Columns)
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, idx)
+		}
+		return indexes, nil
+
+	case dialect.PG:
+		var list []struct {
+			Name    string
+			Unique  bool
+			Primary bool
+			Columns []string `bun:",array"`
+		}
+		err := r.db.NewRaw(`
+			SELECT i.relname AS name, ix.indisunique AS unique, ix.indisprimary AS primary,
+				array_agg(a.attname::text ORDER BY k.n) AS columns
+			FROM pg_class t
+			JOIN pg_index ix ON ix.indrelid = t.oid
+			JOIN pg_class i ON i.oid = ix.indexrelid
+			JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, n) ON true
+			JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+			WHERE t.relname = ? AND t.relnamespace = current_schema()::regnamespace
+			GROUP BY i.relname, ix.indisunique, ix.indisprimary`, table).
+			Scan(ctx, &list)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes := make([]tableIndex, 0, len(list))
+		for _, l := range list {
+			indexes = append(indexes, tableIndex{Name: l.Name, Unique: l.Unique, Primary: l.Primary, Columns: l.Columns})
+		}
+		return indexes, nil
+	}
+
+	return nil, fmt.Errorf("schema drift isn't supported on %s", r.db.Dialect().Name())
+}
+
+func (r *DBRepository) tableForeignKeys(ctx context.Context, table string) ([]tableForeignKey, error) {
+	switch r.db.Dialect().Name() {
+	case dialect.SQLite:
+		var list []struct {
+			Id       int
+			RefTable string
+			Column   string
+		}
+		err := r.db.NewRaw(`SELECT id, "table" AS ref_table, "from" AS "column" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table).
+			Scan(ctx, &list)
+		if err != nil {
+			return nil, err
+		}
+
+		var fks []tableForeignKey
+		for i, l := range list {
+			if i == 0 || l.Id != list[i-1].Id {
+				fks = append(fks, tableForeignKey{RefTable: l.RefTable})
+			}
+			fks[len(fks)-1].Columns = append(fks[len(fks)-1].Columns, l.Column)
+		}
+		return fks, nil
+
+	case dialect.PG:
+		var fks []tableForeignKey
+		err := r.db.NewRaw(`
+			SELECT c.conname AS name, r.relname AS ref_table, array_agg(a.attname::text ORDER BY k.n) AS columns
+			FROM pg_constraint c
+			JOIN pg_class t ON t.oid = c.conrelid
+			JOIN pg_class r ON r.oid = c.confrelid
+			JOIN LATERAL unnest(c.conkey) WITH ORDINALITY AS k(attnum, n) ON true
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+			WHERE c.contype = 'f' AND t.relname = ? AND t.relnamespace = current_schema()::regnamespace
+			GROUP BY c.conname, r.relname`, table).
+			Scan(ctx, &fks)
+		return fks, err
+	}
+
+	return nil, fmt.Errorf("schema drift isn't supported on %s", r.db.Dialect().Name())
+}
+
+func (r *DBRepository) formatQuery(query string, args ...any) string {
+	return schema.NewFormatter(r.db.Dialect()).FormatQuery(query, args...)
+}
+
+func nullability(notNull bool) string {
+	if notNull {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+var sqlTypeSize = regexp.MustCompile(`\s*\(.*\)$`)
+
+// sqlTypeAliases maps the different spellings of a type (by bun & by the databases) to ONE name
+var sqlTypeAliases = map[string]string{
+	"character varying":           "varchar",
+	"int8":                        "bigint",
+	"bigserial":                   "bigint",
+	"int":                         "integer",
+	"int4":                        "integer",
+	"serial":                      "integer",
+	"int2":                        "smallint",
+	"smallserial":                 "smallint",
+	"bool":                        "boolean",
+	"float8":                      "double precision",
+	"float4":                      "real",
+	"decimal":                     "numeric",
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+	"character":                   "char",
+	"bpchar":                      "char",
+}
+
+func normalizeSQLType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+
+	array := strings.HasSuffix(typ, "[]")
+	typ = strings.TrimSuffix(typ, "[]")
+	typ = sqlTypeSize.ReplaceAllString(typ, "")
+
+	if alias, ok := sqlTypeAliases[typ]; ok {
+		typ = alias
+	}
+	if array {
+		typ += "[]"
+	}
+	return typ
+}
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Drift struct {
+	Id    string `bun:",pk"`
+	Title string `bun:",notnull"`
+	Email string `bun:",unique,notnull"`
+}
+
+type Undeployed struct {
+	Id string `bun:",pk"`
+}
+
+func TestDBRepository_DetectSchemaDrift(t *testing.T) {
+	ctx, db, crudRepo := setUp("file:drift?mode=memory&cache=shared")
+
+	err := crudRepo.Migrate(ctx, (*Book)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE drifts (id VARCHAR NOT NULL PRIMARY KEY, title TEXT, legacy INTEGER)`)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// in sync
+	drift, err := crudRepo.DetectSchemaDrift(ctx, (*Book)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Falsef(t, drift.HasDrift(), "expected no drift but got: %+v", drift)
+
+	// drifted
+	drift, err = crudRepo.DetectSchemaDrift(ctx, (*Drift)(nil), (*Undeployed)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	found := map[string]bool{}
+	for _, d := range drift {
+		found[d.Table+"."+d.Column+":"+d.Kind.String()] = true
+	}
+	expected := []string{
+		"drifts.title:column_type", // TEXT vs VARCHAR
+		"drifts.title:nullability",
+		"drifts.email:missing_column",
+		"drifts.email:missing_index",
+		"drifts.legacy:extra_column",
+		"undeployeds.:missing_table",
+	}
+	for _, diff := range expected {
+		assert.Truef(t, found[diff], "expected %+v in: %+v", diff, drift)
+	}
+	assert.Equalf(t, len(expected), len(drift), "expected %+v but got: %+v", len(expected), len(drift))
+
+	migration := drift.DraftMigration()
+	assert.Contains(t, migration, `ALTER TABLE "drifts" ADD COLUMN "email" VARCHAR NOT NULL;`)
+	assert.Contains(t, migration, `CREATE UNIQUE INDEX IF NOT EXISTS "drifts_email_key" ON "drifts" ("email");`)
+	assert.Contains(t, migration, `-- ALTER TABLE "drifts" DROP COLUMN "legacy";`)
+	assert.Truef(t, strings.Contains(migration, `CREATE TABLE "undeployeds"`), "expected create table in: %s", migration)
+}
+
+func Test_normalizeSQLType(t *testing.T) {
+	tests := map[string]string{
+		"VARCHAR(255)":                "varchar",
+		"character varying":           "varchar",
+		"int8":                        "bigint",
+		"BIGSERIAL":                   "bigint",
+		"timestamp with time zone":    "timestamptz",
+		"varchar[]":                   "varchar[]",
+		"NUMERIC(10, 2)":              "numeric",
+		"timestamp without time zone": "timestamp",
+	}
+	for in, want := range tests {
+		assert.Equalf(t, want, normalizeSQLType(in), "expected %+v but got: %+v for %s", want, normalizeSQLType(in), in)
+	}
+}
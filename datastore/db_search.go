@@ -0,0 +1,178 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// DefaultSearchConfig is the postgresql text search configuration of SearchCriteria & MigrateSearch
+const DefaultSearchConfig = "english"
+
+// SearchCriteria matches the records whose columns contain the words of query, best matches first.
+// It needs the index or virtual table created by MigrateSearch with the same columns, & composes
+// with other criteria:
+//
+//	repo.List(ctx, &products, SearchCriteria("red shoes", "name", "description"), paginate)
+//
+// On postgresql query is parsed with websearch_to_tsquery ("quoted phrase", or, -excluded) & ranked
+// with ts_rank. On sqlite every word must match one of columns (prefix matching), ranked by the fts5
+// bm25 rank: columns may be a subset of those given to MigrateSearch. An empty query matches
+// everything.
+func SearchCriteria(query string, columns ...string) SelectCriteria {
+	return SearchCriteriaWithConfig(DefaultSearchConfig, query, columns...)
+}
+
+// SearchCriteriaWithConfig is SearchCriteria with the postgresql text search configuration config
+// (DefaultSearchConfig when empty, ignored on sqlite), eg: "simple" or "french". It needs the index
+// created by MigrateSearchWithConfig with the same config.
+func SearchCriteriaWithConfig(config, query string, columns ...string) SelectCriteria {
+	config = searchConfig(config)
+
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		query := strings.TrimSpace(query)
+		if query == "" || len(columns) == 0 {
+			return q
+		}
+
+		switch q.Dialect().Name() {
+		case dialect.PG:
+			document := searchDocument(columns, "?TableAlias.")
+			return q.
+				Where("to_tsvector(?, "+document+") @@ websearch_to_tsquery(?, ?)", config, config, query).
+				OrderExpr("ts_rank(to_tsvector(?, "+document+"), websearch_to_tsquery(?, ?)) DESC", config, config, query)
+
+		case dialect.SQLite:
+			fts := bun.Ident(searchTable(q.GetTableName()))
+			return q.
+				Join("JOIN ? ON ?.rowid = ?TableAlias.rowid", fts, fts).
+				Where("? MATCH ?", fts, ftsQuery(query, columns)).
+				OrderExpr("?.rank", fts)
+		}
+
+		return q.Err(fmt.Errorf("full-text search isn't supported on %s", q.Dialect().Name()))
+	}
+}
+
+// MigrateSearch creates what SearchCriteria needs to search columns of the model (after Migrate).
+// It is idempotent.
+//
+// On postgresql it creates a GIN index on the tsvector of the columns. On sqlite it creates an fts5
+// virtual table (<table>_fts) indexing the table, the triggers keeping it in sync & indexes the
+// existing records.
+//
+// Usage: MigrateSearch(ctx, (*Product)(nil), "name", "description")
+func (r *DBRepository) MigrateSearch(ctx context.Context, modelPtr any, columns ...string) error {
+	return r.MigrateSearchWithConfig(ctx, modelPtr, DefaultSearchConfig, columns...)
+}
+
+// MigrateSearchWithConfig is MigrateSearch indexing for the postgresql text search configuration
+// config (DefaultSearchConfig when empty, ignored on sqlite), as searched by SearchCriteriaWithConfig.
+func (r *DBRepository) MigrateSearchWithConfig(ctx context.Context, modelPtr any, config string, columns ...string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns to search")
+	}
+
+	typ := reflect.TypeOf(modelPtr)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct, got %T", modelPtr)
+	}
+	table := r.db.Dialect().Tables().Get(typ)
+
+	var statements []string
+	switch r.db.Dialect().Name() {
+	case dialect.PG:
+		statements = append(statements, r.formatQuery(
+			"CREATE INDEX IF NOT EXISTS ? ON ? USING GIN (to_tsvector(?, "+searchDocument(columns, "")+"))",
+			bun.Ident(table.Name+"_search_idx"), table.SQLName, searchConfig(config),
+		))
+
+	case dialect.SQLite:
+		fts := quoteIdent(searchTable(table.Name))
+		cols, newCols, oldCols := identList(columns, ""), identList(columns, "new."), identList(columns, "old.")
+		insertRow := "INSERT INTO " + fts + "(rowid, " + cols + ") VALUES (new.rowid, " + newCols + ");"
+		deleteRow := "INSERT INTO " + fts + "(" + fts + ", rowid, " + cols + ") VALUES ('delete', old.rowid, " + oldCols + ");"
+		trigger := func(event, body string) string {
+			name := quoteIdent(table.Name + "_fts_" + strings.ToLower(event))
+			return "CREATE TRIGGER IF NOT EXISTS " + name + " AFTER " + event + " ON " + string(table.SQLName) + " BEGIN " + body + " END"
+		}
+
+		statements = append(statements,
+			r.formatQuery("CREATE VIRTUAL TABLE IF NOT EXISTS "+fts+" USING fts5("+cols+", content=?, content_rowid='rowid')", table.Name),
+			trigger("INSERT", insertRow),
+			trigger("DELETE", deleteRow),
+			trigger("UPDATE", deleteRow+" "+insertRow),
+			"INSERT INTO "+fts+"("+fts+") VALUES ('rebuild')",
+		)
+
+	default:
+		return fmt.Errorf("full-text search isn't supported on %s", r.db.Dialect().Name())
+	}
+
+	for _, stmt := range statements {
+		if _, err := r.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed creating search resources: %w", err)
+		}
+	}
+	return nil
+}
+
+// searchDocument concatenates the columns as ONE text document
+func searchDocument(columns []string, prefix string) string {
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		parts[i] = "coalesce(" + prefix + quoteIdent(c) + "::text, '')"
+	}
+	return strings.Join(parts, " || ' ' || ")
+}
+
+func identList(columns []string, prefix string) string {
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		parts[i] = prefix + quoteIdent(c)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// quoteIdent quotes an identifier the way both postgresql & sqlite expect it
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func searchTable(table string) string {
+	return table + "_fts"
+}
+
+func searchConfig(config string) string {
+	if config == "" {
+		return DefaultSearchConfig
+	}
+	return config
+}
+
+// ftsQuery turns user input into an fts5 query on columns: every word quoted (no fts5 syntax
+// injection) & prefix matched, eg: {"name" "description"} : ("red"* "sho"*)
+func ftsQuery(query string, columns []string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = ftsString(w) + "*"
+	}
+
+	cols := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = ftsString(c)
+	}
+	return "{" + strings.Join(cols, " ") + "} : (" + strings.Join(words, " ") + ")"
+}
+
+// ftsString quotes s as an fts5 string
+func ftsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
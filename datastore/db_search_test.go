@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+type Product struct {
+	Id          string `bun:",pk"`
+	Name        string `bun:",notnull"`
+	Description string
+	Price       int
+}
+
+func TestDBRepository_SearchCriteria(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:search?mode=memory&cache=shared")
+
+	err := crudRepo.Migrate(ctx, (*Product)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// records existing before MigrateSearch get indexed
+	_, err = crudRepo.Create(ctx, &Product{Id: "p1", Name: "Red shoes", Description: "running shoes", Price: 50}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	for i := 0; i < 2; i++ {
+		err = crudRepo.MigrateSearch(ctx, (*Product)(nil), "name", "description")
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	}
+
+	seed := []Product{
+		{Id: "p2", Name: "Blue shirt", Description: "red stripes", Price: 20},
+		{Id: "p3", Name: "Red red dress", Description: "evening", Price: 80},
+	}
+	_, err = crudRepo.Create(ctx, &seed, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	ids := func(sc ...SelectCriteria) []string {
+		var products []Product
+		err := crudRepo.List(ctx, &products, sc...)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+		var ids []string
+		for _, p := range products {
+			ids = append(ids, p.Id)
+		}
+		return ids
+	}
+
+	// ranked: most relevant first
+	assert.Equal(t, []string{"p3", "p1", "p2"}, ids(SearchCriteria("red", "name", "description")))
+
+	// every word, prefix matched & fts5 syntax neutralised
+	assert.Equal(t, []string{"p1"}, ids(SearchCriteria(`red sho`, "name", "description")))
+	assert.Equal(t, []string(nil), ids(SearchCriteria(`"red" OR NEAR(`, "name", "description")))
+
+	// restricted to columns
+	assert.Equal(t, []string{"p3", "p1"}, ids(SearchCriteria("red", "name")))
+	assert.Equal(t, []string{"p2"}, ids(SearchCriteria("stripes", "description")))
+	assert.Equal(t, []string(nil), ids(SearchCriteria("stripes", "name")))
+
+	// composes with other criteria
+	cheap := func(q *bun.SelectQuery) *bun.SelectQuery { return q.Where("price < ?", 60) }
+	assert.Equal(t, []string{"p1", "p2"}, ids(SearchCriteria("red", "name", "description"), cheap))
+
+	// kept in sync by updates & deletes
+	_, err = crudRepo.Update(ctx, &Product{Id: "p2", Name: "Blue shirt", Description: "plain", Price: 20}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	_, err = crudRepo.DeleteByPK(ctx, &Product{Id: "p3"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, []string{"p1"}, ids(SearchCriteria("red", "name", "description")))
+
+	// the text search configuration is postgresql's: ignored on sqlite
+	assert.Equal(t, []string{"p1"}, ids(SearchCriteriaWithConfig("simple", "red", "name", "description")))
+	err = crudRepo.MigrateSearchWithConfig(ctx, (*Product)(nil), "simple", "name", "description")
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	// empty query matches everything
+	assert.Equal(t, 2, len(ids(SearchCriteria("  ", "name"))))
+}
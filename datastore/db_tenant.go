@@ -0,0 +1,205 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+var (
+	ErrTenantMissing       = errors.New("tenant id not found in context")
+	ErrTenantColumnMissing = errors.New("model does not have the tenant column")
+)
+
+type (
+	tenantCtxKey      struct{}
+	tenantAdminCtxKey struct{}
+)
+
+// WithTenant returns a copy of ctx carrying the tenant id. A tenant scoped
+// DBRepository reads it to filter & stamp every query.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant id stored in ctx by WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantCtxKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// WithTenantAdmin returns a copy of ctx that lifts the tenant scoping.
+// Queries made with it can read & write across tenants, so use it sparingly
+// e.g back-office jobs, migrations etc.
+func WithTenantAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantAdminCtxKey{}, true)
+}
+
+// IsTenantAdmin checks if ctx was created via WithTenantAdmin
+func IsTenantAdmin(ctx context.Context) bool {
+	ok, _ := ctx.Value(tenantAdminCtxKey{}).(bool)
+	return ok
+}
+
+// WithTenantColumn returns a clone of the repository scoped by tenant. Every select,
+// update & delete gets a 'column = tenantID' predicate and every insert has the column set,
+// with the tenantID taken from the context (see WithTenant).
+//
+// Queries without a tenant in the context fail with ErrTenantMissing,
+// unless the context was created via WithTenantAdmin.
+func (r *DBRepository) WithTenantColumn(column string) *DBRepository {
+	clone := *r
+	clone.tenantColumn = column
+	return &clone
+}
+
+// TransactionalInTenantSchema works like Transactional, but first points the postgres
+// search_path to the tenant's own schema (schemaPrefix + tenantID) for the lifetime
+// of the transaction. Used for schema-per-tenant setups.
+func (r *DBRepository) TransactionalInTenantSchema(
+	ctx context.Context, schemaPrefix string, fn func(ctx context.Context, tx bun.Tx) error,
+) error {
+	if r.db.Dialect().Name() != dialect.PG {
+		return errors.New("schema per tenant is only supported on postgresql")
+	}
+
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return ErrTenantMissing
+	}
+
+	return r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.ExecContext(ctx, "SET LOCAL search_path TO ?, public", bun.Ident(schemaPrefix+tenantID))
+		if err != nil {
+			return err
+		}
+		return fn(ctx, tx)
+	})
+}
+
+// tenant returns the tenantID the queries should be scoped to. scoped is false when
+// the repository isn't tenant aware or the context is an admin one.
+func (r *DBRepository) tenant(ctx context.Context) (tenantID string, scoped bool, err error) {
+	if r.tenantColumn == "" || IsTenantAdmin(ctx) {
+		return "", false, nil
+	}
+
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", false, ErrTenantMissing
+	}
+	return tenantID, true, nil
+}
+
+// scopeSelect adds the tenant predicate to a select query
+func (r *DBRepository) scopeSelect(ctx context.Context, q *bun.SelectQuery) error {
+	tenantID, scoped, err := r.tenant(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	q.Where("?TableAlias.? = ?", bun.Ident(r.tenantColumn), tenantID)
+	return nil
+}
+
+// scopeUpdate stamps the models with the tenant & adds the tenant predicate to an update query
+func (r *DBRepository) scopeUpdate(ctx context.Context, q *bun.UpdateQuery, model any) error {
+	tenantID, scoped, err := r.tenant(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	if err := r.setTenant(model, tenantID); err != nil {
+		return err
+	}
+	q.Where("?TableAlias.? = ?", bun.Ident(r.tenantColumn), tenantID)
+	return nil
+}
+
+// scopeDelete adds the tenant predicate to a delete query
+func (r *DBRepository) scopeDelete(ctx context.Context, q *bun.DeleteQuery) error {
+	tenantID, scoped, err := r.tenant(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	q.Where("?TableAlias.? = ?", bun.Ident(r.tenantColumn), tenantID)
+	return nil
+}
+
+// scopeInsert stamps the models about to be inserted with the tenant. When upserting,
+// it also stops a conflicting row of another tenant from being overwritten.
+func (r *DBRepository) scopeInsert(ctx context.Context, q *bun.InsertQuery, model any, upsert bool) error {
+	tenantID, scoped, err := r.tenant(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	if err := r.setTenant(model, tenantID); err != nil {
+		return err
+	}
+	if upsert {
+		q.Where("?TableAlias.? = ?", bun.Ident(r.tenantColumn), tenantID)
+	}
+	return nil
+}
+
+// setTenant sets the tenant column of ONE OR MORE models
+func (r *DBRepository) setTenant(model any, tenantID string) error {
+	typ, structs, err := modelStructs(model)
+	if err != nil {
+		return err
+	}
+
+	field, ok := r.db.Dialect().Tables().Get(typ).FieldMap[r.tenantColumn]
+	if !ok {
+		return fmt.Errorf("%w: %s.%s", ErrTenantColumnMissing, typ.Name(), r.tenantColumn)
+	}
+
+	for _, strct := range structs {
+		if err := field.ScanValue(strct, tenantID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modelStructs returns the struct type & the addressable struct values behind
+// a pointer to a struct or a pointer to a slice of structs (or struct pointers).
+func modelStructs(model any) (reflect.Type, []reflect.Value, error) {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, nil, fmt.Errorf("model must be a non-nil pointer, got %T", model)
+	}
+	v = v.Elem()
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return v.Type(), []reflect.Value{v}, nil
+
+	case reflect.Slice:
+		typ := v.Type().Elem()
+		isPtr := typ.Kind() == reflect.Ptr
+		if isPtr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return nil, nil, fmt.Errorf("model must be a slice of structs, got %T", model)
+		}
+
+		structs := make([]reflect.Value, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if isPtr {
+				if elem.IsNil() {
+					continue
+				}
+				elem = elem.Elem()
+			}
+			structs = append(structs, elem)
+		}
+		return typ, structs, nil
+	}
+
+	return nil, nil, fmt.Errorf("model must be a pointer to a struct or slice, got %T", model)
+}
@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Invoice struct {
+	Id       string `bun:",pk"`
+	TenantId string `bun:",notnull"`
+	Amount   int
+}
+
+func setUpTenant(t *testing.T) (context.Context, context.Context, *DBRepository) {
+	ctx, _, crudRepo := setUp("file:tenant?mode=memory&cache=shared")
+	if err := crudRepo.Migrate(ctx, (*Invoice)(nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	repo := crudRepo.WithTenantColumn("tenant_id")
+
+	tenantA := WithTenant(ctx, "tenantA")
+	seed := []Invoice{{Id: "inv1", Amount: 10}, {Id: "inv2", Amount: 20}}
+	if _, err := repo.Create(tenantA, &seed, true); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tenantB := WithTenant(ctx, "tenantB")
+	seed = []Invoice{{Id: "inv3", Amount: 30}}
+	if _, err := repo.Create(tenantB, &seed, true); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return tenantA, tenantB, repo
+}
+
+func TestDBRepository_WithTenantColumn(t *testing.T) {
+	tenantA, tenantB, repo := setUpTenant(t)
+
+	// insert stamps the tenant
+	invoice := Invoice{Id: "inv1"}
+	err := repo.FindByPK(tenantA, &invoice)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, "tenantA", invoice.TenantId, "expected %+v but got: %+v", "tenantA", invoice.TenantId)
+
+	// cross tenant reads
+	err = repo.FindByPK(tenantB, &Invoice{Id: "inv1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	var invoices []Invoice
+	err = repo.List(tenantA, &invoices)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 2, len(invoices), "expected %+v but got: %+v", 2, len(invoices))
+
+	// cross tenant writes
+	_, err = repo.Update(tenantB, &Invoice{Id: "inv1", Amount: 99}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	_, err = repo.Upsert(tenantB, &[]Invoice{{Id: "inv2", Amount: 99}}, UpsertOptions{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	_, err = repo.DeleteByPK(tenantB, &Invoice{Id: "inv1"}, false)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	invoices = nil
+	err = repo.List(tenantA, &invoices)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want := []Invoice{
+		{Id: "inv1", TenantId: "tenantA", Amount: 10},
+		{Id: "inv2", TenantId: "tenantA", Amount: 20},
+	}
+	assert.Equalf(t, want, invoices, "expected %+v but got: %+v", want, invoices)
+}
+
+func TestDBRepository_WithTenantColumn_AdminAndMissingTenant(t *testing.T) {
+	tenantA, _, repo := setUpTenant(t)
+
+	err := repo.FindByPK(context.TODO(), &Invoice{Id: "inv1"})
+	assert.Equalf(t, ErrTenantMissing, err, "expected %+v but got: %+v", ErrTenantMissing, err)
+
+	_, err = repo.Create(context.TODO(), &Invoice{Id: "inv9"}, false)
+	assert.Equalf(t, ErrTenantMissing, err, "expected %+v but got: %+v", ErrTenantMissing, err)
+
+	var invoices []Invoice
+	err = repo.List(WithTenantAdmin(tenantA), &invoices)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 3, len(invoices), "expected %+v but got: %+v", 3, len(invoices))
+}
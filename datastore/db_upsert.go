@@ -0,0 +1,197 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/schema"
+)
+
+// UpsertOptions configures an Upsert. The zero value upserts on the primary-key, updating every other column.
+type UpsertOptions struct {
+	// ConflictColumns are the columns of the unique index or primary-key detecting existing records.
+	// Defaults to the primary-key.
+	ConflictColumns []string
+	// ConflictConstraint is the name of the unique constraint detecting existing records, instead of
+	// ConflictColumns (postgresql only).
+	ConflictConstraint string
+	// UpdateColumns are the columns updated on existing records. Defaults to every column but the
+	// conflict columns & the primary-key.
+	UpdateColumns []string
+	// ExceptColumns are left untouched on existing records (eg: created_at), when UpdateColumns isn't set.
+	ExceptColumns []string
+	// DoNothing leaves existing records untouched (insert the new ones only).
+	DoNothing bool
+}
+
+// UpsertResult counts what an Upsert did. Records matching an existing one which wasn't updated
+// (DoNothing, or an existing record of another tenant) are Skipped.
+type UpsertResult struct {
+	Inserted int64
+	Updated  int64
+	Skipped  int64
+}
+
+// Upsert inserts ONE OR MORE records, updating (or skipping) the existing ones as per opts.
+//
+// On postgresql the inserted/updated counts come from the system column xmax. sqlite having no
+// equivalent, the existing records are counted first, within the same transaction.
+func (r *DBRepository) Upsert(ctx context.Context, modelsPtr any, opts UpsertOptions) (UpsertResult, error) {
+	typ, structs, err := modelStructs(modelsPtr)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	if len(structs) == 0 {
+		return UpsertResult{}, nil
+	}
+	table := r.db.Dialect().Tables().Get(typ)
+
+	conflict, err := upsertConflictFields(table, opts)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	target := "(" + string(columnList(conflict)) + ")"
+	if opts.ConflictConstraint != "" {
+		if r.db.Dialect().Name() != dialect.PG {
+			return UpsertResult{}, fmt.Errorf("conflict constraints are only supported on postgresql")
+		}
+		target = r.formatQuery("ON CONSTRAINT ?", bun.Ident(opts.ConflictConstraint))
+	}
+
+	update, err := upsertUpdateFields(table, conflict, opts)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	doNothing := opts.DoNothing || len(update) == 0
+
+	q := r.db.NewInsert().Model(modelsPtr)
+	if err := r.scopeInsert(ctx, q, modelsPtr, !doNothing); err != nil {
+		return UpsertResult{}, err
+	}
+
+	if doNothing {
+		q.On("CONFLICT " + target + " DO NOTHING")
+	} else {
+		q.On("CONFLICT " + target + " DO UPDATE")
+		for _, f := range update {
+			q.Set("? = EXCLUDED.?", f.SQLName, f.SQLName)
+		}
+	}
+
+	total := int64(len(structs))
+
+	if r.db.Dialect().Name() == dialect.PG {
+		var inserted []bool
+		if err := q.Returning("(xmax = 0) AS inserted").Scan(ctx, &inserted); err != nil {
+			return UpsertResult{}, err
+		}
+
+		res := UpsertResult{}
+		for _, ins := range inserted {
+			if ins {
+				res.Inserted++
+			} else {
+				res.Updated++
+			}
+		}
+		res.Skipped = total - res.Inserted - res.Updated
+		return res, nil
+	}
+
+	var res UpsertResult
+	err = r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		existing, err := r.countExisting(ctx, tx, table, conflict, structs)
+		if err != nil {
+			return err
+		}
+
+		affected, err := rowsAffected(q.Conn(tx).Exec(ctx))
+		if err != nil {
+			return err
+		}
+
+		res.Inserted = total - existing
+		res.Updated = affected - res.Inserted
+		res.Skipped = total - affected
+		return nil
+	})
+	return res, err
+}
+
+// countExisting counts the records already stored with the conflict values of structs
+func (r *DBRepository) countExisting(
+	ctx context.Context, db bun.IDB, table *schema.Table, conflict []*schema.Field, structs []reflect.Value,
+) (int64, error) {
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(conflict)), ", ") + ")"
+	tuples := make([]string, len(structs))
+	args := make([]any, 0, len(structs)*len(conflict))
+	for i, strct := range structs {
+		tuples[i] = tuple
+		for _, f := range conflict {
+			args = append(args, f.Value(strct).Interface())
+		}
+	}
+
+	var count int64
+	err := db.NewSelect().
+		TableExpr("?", table.SQLName).
+		ColumnExpr("count(*)").
+		Where("("+string(columnList(conflict))+") IN (VALUES "+strings.Join(tuples, ", ")+")", args...).
+		Scan(ctx, &count)
+	return count, err
+}
+
+func upsertConflictFields(table *schema.Table, opts UpsertOptions) ([]*schema.Field, error) {
+	if len(opts.ConflictColumns) == 0 {
+		if len(table.PKs) == 0 && opts.ConflictConstraint == "" {
+			return nil, fmt.Errorf("model %s has no primary-key: set the conflict columns", table.TypeName)
+		}
+		return table.PKs, nil
+	}
+	return fieldsOf(table, opts.ConflictColumns)
+}
+
+func upsertUpdateFields(table *schema.Table, conflict []*schema.Field, opts UpsertOptions) ([]*schema.Field, error) {
+	if len(opts.UpdateColumns) > 0 {
+		return fieldsOf(table, opts.UpdateColumns)
+	}
+
+	skip := make(map[string]bool)
+	for _, f := range conflict {
+		skip[f.Name] = true
+	}
+	for _, f := range table.PKs {
+		skip[f.Name] = true
+	}
+	except, err := fieldsOf(table, opts.ExceptColumns)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range except {
+		skip[f.Name] = true
+	}
+
+	var update []*schema.Field
+	for _, f := range table.Fields {
+		if !skip[f.Name] {
+			update = append(update, f)
+		}
+	}
+	return update, nil
+}
+
+func fieldsOf(table *schema.Table, columns []string) ([]*schema.Field, error) {
+	fields := make([]*schema.Field, len(columns))
+	for i, c := range columns {
+		f, ok := table.FieldMap[c]
+		if !ok {
+			return nil, fmt.Errorf("column %s not found in %s", c, table.Name)
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
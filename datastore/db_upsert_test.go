@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Subscriber struct {
+	Id      int64  `bun:",pk,autoincrement"`
+	Email   string `bun:",unique,notnull"`
+	Name    string
+	Visits  int
+	Created string
+}
+
+func TestDBRepository_UpsertOptions(t *testing.T) {
+	ctx, _, crudRepo := setUp("file:upsert?mode=memory&cache=shared")
+
+	err := crudRepo.Migrate(ctx, (*Subscriber)(nil))
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	list := func() []Subscriber {
+		var subscribers []Subscriber
+		if err := crudRepo.List(ctx, &subscribers); err != nil {
+			t.Fatal(err.Error())
+		}
+		return subscribers
+	}
+
+	// on the primary-key (default)
+	seed := []Subscriber{{Id: 1, Email: "a@b.c", Name: "a", Created: "monday"}}
+	res, err := crudRepo.Upsert(ctx, &seed, UpsertOptions{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, UpsertResult{Inserted: 1}, res)
+
+	seed = []Subscriber{{Id: 1, Email: "a@b.c", Name: "A", Created: "monday"}, {Id: 2, Email: "x@y.z", Name: "x", Created: "monday"}}
+	res, err = crudRepo.Upsert(ctx, &seed, UpsertOptions{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, UpsertResult{Inserted: 1, Updated: 1}, res)
+
+	// on a unique column, updating some columns
+	res, err = crudRepo.Upsert(ctx, &[]Subscriber{{Email: "a@b.c", Name: "ignored", Visits: 5}}, UpsertOptions{
+		ConflictColumns: []string{"email"},
+		UpdateColumns:   []string{"visits"},
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, UpsertResult{Updated: 1}, res)
+
+	// all columns except some
+	res, err = crudRepo.Upsert(ctx, &[]Subscriber{{Email: "x@y.z", Name: "X", Visits: 1, Created: "tuesday"}}, UpsertOptions{
+		ConflictColumns: []string{"email"},
+		ExceptColumns:   []string{"created"},
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, UpsertResult{Updated: 1}, res)
+
+	want := []Subscriber{
+		{Id: 1, Email: "a@b.c", Name: "A", Visits: 5, Created: "monday"},
+		{Id: 2, Email: "x@y.z", Name: "X", Visits: 1, Created: "monday"},
+	}
+	assert.Equal(t, want, list())
+
+	// do nothing
+	res, err = crudRepo.Upsert(ctx, &[]Subscriber{{Email: "a@b.c", Name: "skipped"}, {Email: "new@b.c"}}, UpsertOptions{
+		ConflictColumns: []string{"email"},
+		DoNothing:       true,
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, UpsertResult{Inserted: 1, Skipped: 1}, res)
+	assert.Equal(t, 3, len(list()))
+
+	// invalid options
+	_, err = crudRepo.Upsert(ctx, &seed, UpsertOptions{ConflictConstraint: "subscribers_email_key"})
+	assert.NotNilf(t, err, "expected conflict constraints to be unsupported on sqlite")
+
+	_, err = crudRepo.Upsert(ctx, &seed, UpsertOptions{ConflictColumns: []string{"missing"}})
+	assert.NotNilf(t, err, "expected an unknown column error")
+}
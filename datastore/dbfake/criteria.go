@@ -0,0 +1,574 @@
+package dbfake
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedCriteria is returned when a criteria renders sql the fake can't evaluate
+// e.g joins, functions, sub-queries, group by etc.
+var ErrUnsupportedCriteria = errors.New("dbfake: unsupported criteria")
+
+// criteria is the evaluable form of the sql rendered by SelectCriteria or DeleteCriteria.
+// Only simple predicates are supported:
+//
+//	column (=, <>, !=, <, <=, >, >=) value
+//	column [NOT] IN (values...)
+//	column [NOT] LIKE value
+//	column IS [NOT] NULL
+//
+// combined with AND, OR, NOT & parentheses, plus ORDER BY columns, LIMIT & OFFSET.
+type criteria struct {
+	where   expr
+	orderBy []order
+	limit   int
+	offset  int
+}
+
+type order struct {
+	column string
+	desc   bool
+}
+
+// expr is a boolean expression evaluated against a row
+type expr interface {
+	eval(row func(column string) (any, error)) (bool, error)
+}
+
+type (
+	andExpr struct{ left, right expr }
+	orExpr  struct{ left, right expr }
+	notExpr struct{ inner expr }
+
+	compareExpr struct {
+		column string
+		op     string
+		value  any
+	}
+	inExpr struct {
+		column string
+		values []any
+		not    bool
+	}
+	likeExpr struct {
+		column  string
+		pattern *regexp.Regexp
+		not     bool
+	}
+	nullExpr struct {
+		column string
+		not    bool
+	}
+)
+
+func (e andExpr) eval(row func(string) (any, error)) (bool, error) {
+	ok, err := e.left.eval(row)
+	if err != nil || !ok {
+		return false, err
+	}
+	return e.right.eval(row)
+}
+
+func (e orExpr) eval(row func(string) (any, error)) (bool, error) {
+	ok, err := e.left.eval(row)
+	if err != nil || ok {
+		return ok, err
+	}
+	return e.right.eval(row)
+}
+
+func (e notExpr) eval(row func(string) (any, error)) (bool, error) {
+	ok, err := e.inner.eval(row)
+	return !ok, err
+}
+
+func (e compareExpr) eval(row func(string) (any, error)) (bool, error) {
+	v, err := row(e.column)
+	if err != nil {
+		return false, err
+	}
+	cmp, ok := compare(v, e.value)
+	if !ok {
+		return false, nil
+	}
+
+	switch e.op {
+	case "=":
+		return cmp == 0, nil
+	case "<>", "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("%w: operator %s", ErrUnsupportedCriteria, e.op)
+}
+
+func (e inExpr) eval(row func(string) (any, error)) (bool, error) {
+	v, err := row(e.column)
+	if err != nil || v == nil {
+		return false, err
+	}
+	for _, value := range e.values {
+		if cmp, ok := compare(v, value); ok && cmp == 0 {
+			return !e.not, nil
+		}
+	}
+	return e.not, nil
+}
+
+func (e likeExpr) eval(row func(string) (any, error)) (bool, error) {
+	v, err := row(e.column)
+	if err != nil || v == nil {
+		return false, err
+	}
+	return e.pattern.MatchString(fmt.Sprint(v)) != e.not, nil
+}
+
+func (e nullExpr) eval(row func(string) (any, error)) (bool, error) {
+	v, err := row(e.column)
+	if err != nil {
+		return false, err
+	}
+	return (v == nil) != e.not, nil
+}
+
+// compare compares two sql values. ok is false when either is NULL or the types differ.
+func compare(a, b any) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, isNum := b.(float64); isNum {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, isStr := b.(string); isStr {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, isBool := b.(bool); isBool {
+			switch {
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// ------------------------------------------------------------------------------
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(keyword string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, keyword)
+}
+
+// tokenize splits sql into words, quoted identifiers, strings, numbers & symbols
+func tokenize(sql string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(sql); j++ {
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c { // escaped quote
+						b.WriteByte(c)
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(sql[j])
+			}
+			if j >= len(sql) {
+				return nil, fmt.Errorf("%w: unterminated quote in %s", ErrUnsupportedCriteria, sql)
+			}
+
+			kind := tokString
+			if c == '"' {
+				kind = tokIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: b.String()})
+			i = j + 1
+
+		case isDigit(c) || (c == '-' && i+1 < len(sql) && isDigit(sql[i+1])):
+			j := i + 1
+			for j < len(sql) && (isDigit(sql[j]) || sql[j] == '.' || sql[j] == 'e' || sql[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: sql[i:j]})
+			i = j
+
+		case isWordChar(c):
+			j := i
+			for j < len(sql) && (isWordChar(sql[j]) || isDigit(sql[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokWord, text: sql[i:j]})
+			i = j
+
+		default:
+			for _, op := range []string{"<>", "!=", "<=", ">="} {
+				if strings.HasPrefix(sql[i:], op) {
+					tokens = append(tokens, token{kind: tokSymbol, text: op})
+					i += len(op)
+					goto next
+				}
+			}
+			tokens = append(tokens, token{kind: tokSymbol, text: string(c)})
+			i++
+		next:
+		}
+	}
+
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseQuery parses the sql of a SELECT or DELETE query rendered by bun
+func parseQuery(sql string) (*criteria, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, sql: sql}
+
+	// skip to the end of: FROM "table" AS "alias"
+	for !p.done() && !p.peek().is("FROM") {
+		p.pos++
+	}
+	if !p.next().is("FROM") || p.next().kind != tokIdent {
+		return nil, p.unsupported()
+	}
+	if p.peek().is("AS") {
+		p.pos++
+		if p.next().kind != tokIdent {
+			return nil, p.unsupported()
+		}
+	}
+
+	c := &criteria{limit: -1}
+
+	if p.peek().is("WHERE") {
+		p.pos++
+		if c.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().is("ORDER") {
+		p.pos++
+		if !p.next().is("BY") {
+			return nil, p.unsupported()
+		}
+		for {
+			column, err := p.parseColumn()
+			if err != nil {
+				return nil, err
+			}
+			o := order{column: column}
+			switch {
+			case p.peek().is("DESC"):
+				o.desc = true
+				p.pos++
+			case p.peek().is("ASC"):
+				p.pos++
+			}
+			c.orderBy = append(c.orderBy, o)
+
+			if p.peek().text != "," {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if p.peek().is("LIMIT") {
+		p.pos++
+		if c.limit, err = p.parseInt(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().is("OFFSET") {
+		p.pos++
+		if c.offset, err = p.parseInt(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.done() {
+		return nil, p.unsupported()
+	}
+	return c, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	sql    string
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokSymbol}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) unsupported() error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedCriteria, p.sql)
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.peek().is("NOT") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner: inner}, nil
+	}
+
+	if p.peek().text == "(" && p.peek().kind == tokSymbol {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().text != ")" {
+			return nil, p.unsupported()
+		}
+		return inner, nil
+	}
+
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (expr, error) {
+	column, err := p.parseColumn()
+	if err != nil {
+		return nil, err
+	}
+
+	not := false
+	if p.peek().is("NOT") {
+		not = true
+		p.pos++
+	}
+
+	t := p.next()
+	switch {
+	case t.is("IS"):
+		if p.peek().is("NOT") {
+			not = !not
+			p.pos++
+		}
+		if !p.next().is("NULL") {
+			return nil, p.unsupported()
+		}
+		return nullExpr{column: column, not: not}, nil
+
+	case t.is("IN"):
+		if p.next().text != "(" {
+			return nil, p.unsupported()
+		}
+		var values []any
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+
+			sep := p.next().text
+			if sep == ")" {
+				break
+			}
+			if sep != "," {
+				return nil, p.unsupported()
+			}
+		}
+		return inExpr{column: column, values: values, not: not}, nil
+
+	case t.is("LIKE"):
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		pattern, ok := v.(string)
+		if !ok {
+			return nil, p.unsupported()
+		}
+		return likeExpr{column: column, pattern: likePattern(pattern), not: not}, nil
+
+	case t.kind == tokSymbol && !not:
+		switch t.text {
+		case "=", "<>", "!=", "<", "<=", ">", ">=":
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			return compareExpr{column: column, op: t.text, value: v}, nil
+		}
+	}
+
+	return nil, p.unsupported()
+}
+
+// parseColumn parses column, "column" or "alias"."column"
+func (p *parser) parseColumn() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent && t.kind != tokWord {
+		return "", p.unsupported()
+	}
+
+	column := t.text
+	if p.peek().text == "." && p.peek().kind == tokSymbol {
+		p.pos++
+		t = p.next()
+		if t.kind != tokIdent && t.kind != tokWord {
+			return "", p.unsupported()
+		}
+		column = t.text
+	}
+
+	if p.peek().text == "(" && p.peek().kind == tokSymbol { // a function call
+		return "", p.unsupported()
+	}
+	return column, nil
+}
+
+func (p *parser) parseValue() (any, error) {
+	return literal(p.next(), p.unsupported)
+}
+
+func (p *parser) parseInt() (int, error) {
+	t := p.next()
+	if t.kind != tokNumber {
+		return 0, p.unsupported()
+	}
+	return strconv.Atoi(t.text)
+}
+
+// literal converts a token into a comparable value: string, float64, bool or nil
+func literal(t token, unsupported func() error) (any, error) {
+	switch {
+	case t.kind == tokString:
+		return t.text, nil
+	case t.kind == tokNumber:
+		return strconv.ParseFloat(t.text, 64)
+	case t.is("NULL"):
+		return nil, nil
+	case t.is("TRUE"):
+		return true, nil
+	case t.is("FALSE"):
+		return false, nil
+	}
+	return nil, unsupported()
+}
+
+// likePattern converts a sql LIKE pattern to a (case-insensitive) regexp
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,28 @@
+package dbfake
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseQuery(t *testing.T) {
+	c, err := parseQuery(`SELECT "book"."id" FROM "books" AS "book" WHERE ("book"."title" = 'it''s') ORDER BY "book"."id" DESC, pages LIMIT 10 OFFSET 5`)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, compareExpr{column: "title", op: "=", value: "it's"}, c.where)
+	assert.Equal(t, []order{{column: "id", desc: true}, {column: "pages"}}, c.orderBy)
+	assert.Equal(t, 10, c.limit)
+	assert.Equal(t, 5, c.offset)
+
+	unsupported := []string{
+		`SELECT * FROM "books" AS "book" JOIN "authors" AS "author" ON "author"."id" = "book"."author_id"`,
+		`SELECT * FROM "books" AS "book" GROUP BY "book"."title"`,
+		`SELECT * FROM "books" AS "book" WHERE (pages > (SELECT 1))`,
+		`SELECT * FROM "books" AS "book" WHERE (title = other_column)`,
+	}
+	for _, sql := range unsupported {
+		_, err := parseQuery(sql)
+		assert.Truef(t, errors.Is(err, ErrUnsupportedCriteria), "expected %+v but got: %+v for %s", ErrUnsupportedCriteria, err, sql)
+	}
+}
@@ -0,0 +1,797 @@
+// Package dbfake provides an in-memory fake of datastore.IDBRepository, so services
+// depending on the repository can be unit tested without a database.
+//
+//	repo := dbfake.New()
+//	svc := NewBookService(repo)
+//	...
+//	assert.Equal(t, 1, repo.CallCount("Create"))
+package dbfake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/schema"
+)
+
+var _ datastore.IDBRepository = (*Repository)(nil)
+
+// ErrDuplicateKey is returned when creating a record whose primary key already exists
+var ErrDuplicateKey = errors.New("dbfake: duplicate primary key")
+
+// Call is a recorded call made to the repository
+type Call struct {
+	Method string
+	Model  any
+}
+
+// Repository is an in-memory datastore.IDBRepository. Records are stored per model type,
+// indexed by primary-key. Criteria are rendered to sql (by bun, without a database) and
+// evaluated in memory; only simple predicates are supported (see ErrUnsupportedCriteria).
+//
+// Transactional rolls back every change made within it when fn returns an error.
+// The bun.Tx passed to fn is a zero value, use NewWithTx(tx) and not tx directly.
+type Repository struct {
+	mu     *sync.Mutex
+	db     *bun.DB // dialect only, used to parse models & render criteria
+	tables map[reflect.Type]*table
+	calls  []Call
+}
+
+type table struct {
+	schema *schema.Table
+	keys   []string // insertion order
+	rows   map[string]reflect.Value
+	serial int64
+}
+
+// New creates an empty in-memory repository
+func New() *Repository {
+	return &Repository{
+		mu:     &sync.Mutex{},
+		db:     bun.NewDB(sql.OpenDB(noopConnector{}), sqlitedialect.New()),
+		tables: make(map[reflect.Type]*table),
+	}
+}
+
+// Calls returns the calls made to the repository, in order
+func (r *Repository) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// CallCount returns the number of calls made to method e.g CallCount("FindByPK")
+func (r *Repository) CallCount(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, c := range r.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Repository) Migrate(ctx context.Context, modelsPtr ...any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("Migrate", modelsPtr)
+	for _, model := range modelsPtr {
+		if _, err := r.table(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) Create(ctx context.Context, modelPtr any, ignoreDupicates bool) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("Create", modelPtr)
+	return r.insert(modelPtr, ignoreDupicates)
+}
+
+func (r *Repository) CreateInBatches(ctx context.Context, modelsPtr any, batchSize int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("CreateInBatches", modelsPtr)
+	if batchSize < 1 {
+		return 0, errors.New("batch size must be greater than zero")
+	}
+
+	return r.insert(modelsPtr, false)
+}
+
+func (r *Repository) Upsert(ctx context.Context, modelsPtr any, opts datastore.UpsertOptions) (datastore.UpsertResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("Upsert", modelsPtr)
+
+	var res datastore.UpsertResult
+	if opts.ConflictConstraint != "" {
+		return res, fmt.Errorf("%w: conflict constraint", ErrUnsupportedCriteria)
+	}
+
+	t, structs, err := r.structs(modelsPtr)
+	if err != nil {
+		return res, err
+	}
+
+	conflict, err := t.fields(opts.ConflictColumns, t.schema.PKs)
+	if err != nil {
+		return res, err
+	}
+	update, err := t.upsertFields(conflict, opts)
+	if err != nil {
+		return res, err
+	}
+
+	// a statement: a failing row undoes the batch
+	snapshot := t.clone()
+	for _, strct := range structs {
+		key, existing, found := t.findBy(r.db, conflict, strct)
+		if !found {
+			if _, err := r.insertOne(t, strct, false); err != nil {
+				*t = *snapshot
+				return datastore.UpsertResult{}, err
+			}
+			res.Inserted++
+			continue
+		}
+
+		if opts.DoNothing || len(update) == 0 {
+			res.Skipped++
+			continue
+		}
+
+		// stored rows are replaced, never changed in place: snapshots (eg: of Transactional) share them
+		row := copyStruct(existing)
+		for _, f := range update {
+			f.Value(row).Set(f.Value(strct))
+		}
+		t.rows[key] = row
+		res.Updated++
+	}
+	return res, nil
+}
+
+func (r *Repository) Update(ctx context.Context, modelPtr any, mustExist bool) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("Update", modelPtr)
+
+	n, err := r.update(modelPtr)
+	if err == nil && mustExist && n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return n, err
+}
+
+func (r *Repository) UpdateBulk(ctx context.Context, modelPtr any) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("UpdateBulk", modelPtr)
+	return r.update(modelPtr)
+}
+
+func (r *Repository) FindByPK(ctx context.Context, modelPtr any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("FindByPK", modelPtr)
+
+	t, structs, err := r.structs(modelPtr)
+	if err != nil {
+		return err
+	}
+	if len(structs) != 1 {
+		return fmt.Errorf("dbfake: FindByPK expects a pointer to a struct, got %T", modelPtr)
+	}
+
+	row, ok := t.rows[t.key(r.db, structs[0])]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	structs[0].Set(row)
+	return nil
+}
+
+func (r *Repository) FindWhere(ctx context.Context, modelPtr any, sc ...datastore.SelectCriteria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("FindWhere", modelPtr)
+
+	rows, err := r.selectRows(modelPtr, append(sc, limitOne)...)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return sql.ErrNoRows
+	}
+
+	return fill(modelPtr, rows)
+}
+
+func (r *Repository) List(ctx context.Context, modelPtr any, sc ...datastore.SelectCriteria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("List", modelPtr)
+
+	rows, err := r.selectRows(modelPtr, sc...)
+	if err != nil {
+		return err
+	}
+	return fill(modelPtr, rows)
+}
+
+func (r *Repository) Each(ctx context.Context, modelPtr any, fn func(ctx context.Context) error, sc ...datastore.SelectCriteria) error {
+	r.mu.Lock()
+	r.record("Each", modelPtr)
+	rows, err := r.selectRows(modelPtr, sc...)
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(modelPtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct, got %T", modelPtr)
+	}
+
+	for _, row := range rows {
+		v.Elem().Set(copyStruct(row))
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) EachChunk(
+	ctx context.Context, modelsPtr any, chunkSize int, fn func(ctx context.Context) error, sc ...datastore.SelectCriteria,
+) error {
+	if chunkSize < 1 {
+		return errors.New("chunk size must be greater than zero")
+	}
+
+	r.mu.Lock()
+	r.record("EachChunk", modelsPtr)
+	rows, err := r.selectRows(modelsPtr, sc...)
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		if err := fill(modelsPtr, rows[start:end]); err != nil {
+			return err
+		}
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) DeleteByPK(ctx context.Context, modelPtr any, mustExist bool) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("DeleteByPK", modelPtr)
+
+	t, structs, err := r.structs(modelPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, strct := range structs {
+		if t.delete(t.key(r.db, strct)) {
+			n++
+		}
+	}
+
+	if mustExist && n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return n, nil
+}
+
+func (r *Repository) DeleteWhere(ctx context.Context, modelPtr any, dc ...datastore.DeleteCriteria) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("DeleteWhere", modelPtr)
+
+	t, err := r.table(modelPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	q := r.db.NewDelete().Model(reflect.New(t.schema.Type).Interface())
+	for i := range dc {
+		q.Apply(dc[i])
+	}
+
+	c, err := parseQuery(q.String())
+	if err != nil {
+		return 0, err
+	}
+
+	rows, keys, err := t.filter(r.db, c)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		t.delete(key)
+	}
+	return int64(len(rows)), nil
+}
+
+// NewWithTx returns the repository itself, as the fake handles transactions in Transactional
+func (r *Repository) NewWithTx(tx bun.Tx) datastore.IDBRepository {
+	return r
+}
+
+// Transactional runs fn, rolling back every change made by it when it returns an error
+func (r *Repository) Transactional(ctx context.Context, fn func(ctx context.Context, tx bun.Tx) error) error {
+	r.mu.Lock()
+	r.record("Transactional", nil)
+	snapshot := make(map[reflect.Type]*table, len(r.tables))
+	for typ, t := range r.tables {
+		snapshot[typ] = t.clone()
+	}
+	r.mu.Unlock()
+
+	if err := fn(ctx, bun.Tx{}); err != nil {
+		r.mu.Lock()
+		r.tables = snapshot
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------
+
+func (r *Repository) record(method string, model any) {
+	r.calls = append(r.calls, Call{Method: method, Model: model})
+}
+
+// table returns the table of a model, registering it on first use
+func (r *Repository) table(model any) (*table, error) {
+	typ := reflect.TypeOf(model)
+	for typ != nil && (typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice) {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("model must be a pointer to a struct or slice, got %T", model)
+	}
+
+	t, ok := r.tables[typ]
+	if !ok {
+		t = &table{
+			schema: r.db.Dialect().Tables().Get(typ),
+			rows:   make(map[string]reflect.Value),
+		}
+		if len(t.schema.PKs) == 0 {
+			return nil, fmt.Errorf("dbfake: model %s has no primary key", typ.Name())
+		}
+		r.tables[typ] = t
+	}
+	return t, nil
+}
+
+// structs returns the table & the struct values behind a pointer to a struct or slice
+func (r *Repository) structs(model any) (*table, []reflect.Value, error) {
+	t, err := r.table(model)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, nil, fmt.Errorf("model must be a non-nil pointer, got %T", model)
+	}
+	v = v.Elem()
+
+	if v.Kind() == reflect.Struct {
+		return t, []reflect.Value{v}, nil
+	}
+
+	structs := make([]reflect.Value, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		structs = append(structs, elem)
+	}
+	return t, structs, nil
+}
+
+// insert inserts the models all, or none on error (as a statement)
+func (r *Repository) insert(modelsPtr any, ignoreDupicates bool) (int64, error) {
+	t, structs, err := r.structs(modelsPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshot := t.clone()
+	var n int64
+	for _, strct := range structs {
+		inserted, err := r.insertOne(t, strct, ignoreDupicates)
+		if err != nil {
+			*t = *snapshot
+			return 0, err
+		}
+		if inserted {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *Repository) insertOne(t *table, strct reflect.Value, ignoreDupicates bool) (bool, error) {
+	t.autoIncrement(strct)
+
+	key := t.key(r.db, strct)
+	if _, ok := t.rows[key]; ok {
+		if ignoreDupicates {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s %s", ErrDuplicateKey, t.schema.Name, key)
+	}
+
+	t.keys = append(t.keys, key)
+	t.rows[key] = copyStruct(strct)
+	return true, nil
+}
+
+func (r *Repository) update(modelsPtr any) (int64, error) {
+	t, structs, err := r.structs(modelsPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, strct := range structs {
+		key := t.key(r.db, strct)
+		if _, ok := t.rows[key]; ok {
+			t.rows[key] = copyStruct(strct)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// selectRows returns copies of the rows matching the criteria
+func (r *Repository) selectRows(modelPtr any, sc ...datastore.SelectCriteria) ([]reflect.Value, error) {
+	t, err := r.table(modelPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.db.NewSelect().Model(reflect.New(t.schema.Type).Interface())
+	for i := range sc {
+		q.Apply(sc[i])
+	}
+
+	c, err := parseQuery(q.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rows, _, err := t.filter(r.db, c)
+	return rows, err
+}
+
+// ------------------------------------------------------------------------------
+
+func (t *table) clone() *table {
+	rows := make(map[string]reflect.Value, len(t.rows))
+	for key, row := range t.rows {
+		rows[key] = row
+	}
+	return &table{
+		schema: t.schema,
+		keys:   append([]string(nil), t.keys...),
+		rows:   rows,
+		serial: t.serial,
+	}
+}
+
+// key renders the primary-key(s) of a struct
+func (t *table) key(db *bun.DB, strct reflect.Value) string {
+	parts := make([]string, len(t.schema.PKs))
+	for i, pk := range t.schema.PKs {
+		parts[i] = string(pk.AppendValue(db.Formatter(), nil, strct))
+	}
+	return strings.Join(parts, ",")
+}
+
+// fields returns the fields of columns, or defaults when there are none
+func (t *table) fields(columns []string, defaults []*schema.Field) ([]*schema.Field, error) {
+	if len(columns) == 0 {
+		return defaults, nil
+	}
+
+	fields := make([]*schema.Field, len(columns))
+	for i, c := range columns {
+		f, ok := t.schema.FieldMap[c]
+		if !ok {
+			return nil, fmt.Errorf("dbfake: column %s not found in %s", c, t.schema.Name)
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// upsertFields returns the fields an upsert updates, as DBRepository.Upsert does
+func (t *table) upsertFields(conflict []*schema.Field, opts datastore.UpsertOptions) ([]*schema.Field, error) {
+	if len(opts.UpdateColumns) > 0 {
+		return t.fields(opts.UpdateColumns, nil)
+	}
+
+	except, err := t.fields(opts.ExceptColumns, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[*schema.Field]bool)
+	for _, fields := range [][]*schema.Field{conflict, t.schema.PKs, except} {
+		for _, f := range fields {
+			skip[f] = true
+		}
+	}
+
+	var update []*schema.Field
+	for _, f := range t.schema.Fields {
+		if !skip[f] {
+			update = append(update, f)
+		}
+	}
+	return update, nil
+}
+
+// findBy returns the stored row (and its key) having the values of strct in fields
+func (t *table) findBy(db *bun.DB, fields []*schema.Field, strct reflect.Value) (string, reflect.Value, bool) {
+	for _, key := range t.keys {
+		row, ok := t.rows[key]
+		if !ok {
+			continue
+		}
+
+		match := true
+		for _, f := range fields {
+			if string(f.AppendValue(db.Formatter(), nil, row)) != string(f.AppendValue(db.Formatter(), nil, strct)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return key, row, true
+		}
+	}
+	return "", reflect.Value{}, false
+}
+
+// autoIncrement sets a zero auto-increment primary key to the next serial
+func (t *table) autoIncrement(strct reflect.Value) {
+	for _, pk := range t.schema.PKs {
+		if !pk.AutoIncrement || !pk.HasZeroValue(strct) {
+			continue
+		}
+		t.serial++
+		_ = pk.ScanValue(strct, t.serial)
+	}
+}
+
+func (t *table) delete(key string) bool {
+	if _, ok := t.rows[key]; !ok {
+		return false
+	}
+	delete(t.rows, key)
+	for i, k := range t.keys {
+		if k == key {
+			t.keys = append(t.keys[:i], t.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// filter returns copies of the rows (and their keys) matching the criteria
+func (t *table) filter(db *bun.DB, c *criteria) ([]reflect.Value, []string, error) {
+	type match struct {
+		key string
+		row reflect.Value
+	}
+
+	var matches []match
+	for _, key := range t.keys {
+		row := t.rows[key]
+		if c.where != nil {
+			ok, err := c.where.eval(t.column(db, row))
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		matches = append(matches, match{key: key, row: row})
+	}
+
+	var sortErr error
+	if len(c.orderBy) > 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, o := range c.orderBy {
+				a, err := t.column(db, matches[i].row)(o.column)
+				if err != nil {
+					sortErr = err
+					return false
+				}
+				b, _ := t.column(db, matches[j].row)(o.column)
+
+				cmp := compareForOrder(a, b)
+				if cmp == 0 {
+					continue
+				}
+				if o.desc {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+			return false
+		})
+	}
+	if sortErr != nil {
+		return nil, nil, sortErr
+	}
+
+	if c.offset > 0 {
+		if c.offset >= len(matches) {
+			matches = nil
+		} else {
+			matches = matches[c.offset:]
+		}
+	}
+	if c.limit >= 0 && c.limit < len(matches) {
+		matches = matches[:c.limit]
+	}
+
+	rows := make([]reflect.Value, len(matches))
+	keys := make([]string, len(matches))
+	for i, m := range matches {
+		rows[i] = copyStruct(m.row)
+		keys[i] = m.key
+	}
+	return rows, keys, nil
+}
+
+// column returns a func rendering the column of row the same way bun renders values in queries
+func (t *table) column(db *bun.DB, row reflect.Value) func(column string) (any, error) {
+	return func(column string) (any, error) {
+		field, ok := t.schema.FieldMap[column]
+		if !ok {
+			return nil, fmt.Errorf("dbfake: %s has no column %q", t.schema.Name, column)
+		}
+
+		rendered := string(field.AppendValue(db.Formatter(), nil, row))
+		tokens, err := tokenize(rendered)
+		if err != nil || len(tokens) != 1 {
+			return nil, fmt.Errorf("%w: comparing %s.%s", ErrUnsupportedCriteria, t.schema.Name, column)
+		}
+		return literal(tokens[0], func() error {
+			return fmt.Errorf("%w: comparing %s.%s", ErrUnsupportedCriteria, t.schema.Name, column)
+		})
+	}
+}
+
+// compareForOrder orders NULLs first, like sqlite does
+func compareForOrder(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	cmp, _ := compare(a, b)
+	return cmp
+}
+
+// fill sets modelPtr (a pointer to a struct or slice) to the rows
+func fill(modelPtr any, rows []reflect.Value) error {
+	v := reflect.ValueOf(modelPtr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("model must be a non-nil pointer, got %T", modelPtr)
+	}
+	v = v.Elem()
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if len(rows) > 0 {
+			v.Set(rows[0])
+		}
+		return nil
+
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), len(rows), len(rows))
+		for i, row := range rows {
+			if v.Type().Elem().Kind() == reflect.Ptr {
+				ptr := reflect.New(row.Type())
+				ptr.Elem().Set(row)
+				slice.Index(i).Set(ptr)
+				continue
+			}
+			slice.Index(i).Set(row)
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	return fmt.Errorf("model must be a pointer to a struct or slice, got %T", modelPtr)
+}
+
+func copyStruct(v reflect.Value) reflect.Value {
+	cp := reflect.New(v.Type()).Elem()
+	cp.Set(v)
+	return cp
+}
+
+func limitOne(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.Limit(1)
+}
+
+// noopConnector lets bun render queries without ever connecting to a database
+type noopConnector struct{}
+
+func (noopConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("dbfake: no database connection")
+}
+
+func (noopConnector) Driver() driver.Driver {
+	return noopDriver{}
+}
+
+type noopDriver struct{}
+
+func (noopDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbfake: no database connection")
+}
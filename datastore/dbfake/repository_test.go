@@ -0,0 +1,268 @@
+package dbfake
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+type Book struct {
+	Id     string `bun:",pk"`
+	Title  string `bun:",notnull"`
+	Pages  int
+	Author *string
+}
+
+func seed(t *testing.T) (context.Context, *Repository, []Book) {
+	ctx := context.TODO()
+	repo := New()
+
+	author := "tolkien"
+	seedBooks := []Book{
+		{Id: "book1", Title: "hello", Pages: 100, Author: &author},
+		{Id: "book2", Title: "hello world", Pages: 200},
+		{Id: "book3", Title: "goodbye", Pages: 300},
+	}
+
+	n, err := repo.Create(ctx, &seedBooks, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equalf(t, int64(3), n, "expected %+v but got: %+v", 3, n)
+
+	return ctx, repo, seedBooks
+}
+
+func TestRepository_Create_FindByPK_And_Update(t *testing.T) {
+	ctx, repo, seedBooks := seed(t)
+
+	// duplicates
+	_, err := repo.Create(ctx, &Book{Id: "book1"}, false)
+	assert.Truef(t, errors.Is(err, ErrDuplicateKey), "expected %+v but got: %+v", ErrDuplicateKey, err)
+
+	n, err := repo.Create(ctx, &Book{Id: "book1"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(0), n, "expected %+v but got: %+v", 0, n)
+
+	// a duplicate amid a slice: nothing inserted
+	_, err = repo.Create(ctx, &[]Book{{Id: "book4"}, {Id: "book1"}}, false)
+	assert.Truef(t, errors.Is(err, ErrDuplicateKey), "expected %+v but got: %+v", ErrDuplicateKey, err)
+	err = repo.FindByPK(ctx, &Book{Id: "book4"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	// find
+	book := Book{Id: "book1"}
+	err = repo.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, seedBooks[0], book, "expected %+v but got: %+v", seedBooks[0], book)
+
+	err = repo.FindByPK(ctx, &Book{Id: "missing"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	// stored records don't alias the caller's struct
+	book.Title = "changed"
+	err = repo.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, "hello", book.Title)
+
+	// update
+	_, err = repo.Update(ctx, &Book{Id: "missing"}, true)
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	n, err = repo.Update(ctx, &Book{Id: "book1", Title: "updated"}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(1), n, "expected %+v but got: %+v", 1, n)
+
+	// upsert
+	res, err := repo.Upsert(ctx, &[]Book{{Id: "book1", Title: "upserted"}, {Id: "book4", Title: "new"}}, datastore.UpsertOptions{})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want := datastore.UpsertResult{Inserted: 1, Updated: 1}
+	assert.Equalf(t, want, res, "expected %+v but got: %+v", want, res)
+
+	res, err = repo.Upsert(ctx, &[]Book{{Id: "book9", Title: "new", Pages: 1}}, datastore.UpsertOptions{
+		ConflictColumns: []string{"title"}, UpdateColumns: []string{"pages"},
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	want = datastore.UpsertResult{Updated: 1}
+	assert.Equalf(t, want, res, "expected %+v but got: %+v", want, res)
+
+	var books []Book
+	err = repo.List(ctx, &books)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, 4, len(books), "expected %+v but got: %+v", 4, len(books))
+	assert.Equal(t, "upserted", books[0].Title)
+
+	// recorded calls
+	assert.Equal(t, 4, repo.CallCount("Create"))
+	assert.Equal(t, 4, repo.CallCount("FindByPK"))
+	assert.Equal(t, 2, repo.CallCount("Update"))
+	assert.Equal(t, "Create", repo.Calls()[0].Method)
+}
+
+func TestRepository_Criteria(t *testing.T) {
+	ctx, repo, seedBooks := seed(t)
+
+	tests := []struct {
+		name     string
+		criteria []datastore.SelectCriteria
+		want     []Book
+	}{
+		{
+			name: "equal",
+			criteria: []datastore.SelectCriteria{func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("id = ?", "book2")
+			}},
+			want: []Book{seedBooks[1]},
+		},
+		{
+			name: "and, or & comparison",
+			criteria: []datastore.SelectCriteria{func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("?TableAlias.pages >= ?", 200).WhereOr("title = ?", "hello")
+			}},
+			want: seedBooks,
+		},
+		{
+			name: "like, order & limit",
+			criteria: []datastore.SelectCriteria{func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("title LIKE ?", "HELLO%").Order("pages DESC").Limit(1)
+			}},
+			want: []Book{seedBooks[1]},
+		},
+		{
+			name: "in & null",
+			criteria: []datastore.SelectCriteria{func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("id IN (?)", bun.In([]string{"book1", "book3"})).Where("author IS NULL")
+			}},
+			want: []Book{seedBooks[2]},
+		},
+		{
+			name: "offset",
+			criteria: []datastore.SelectCriteria{func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Order("id").Offset(1)
+			}},
+			want: []Book{seedBooks[1], seedBooks[2]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var books []Book
+			err := repo.List(ctx, &books, tt.criteria...)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+			assert.Equalf(t, tt.want, books, "expected %+v but got: %+v", tt.want, books)
+		})
+	}
+
+	// find where
+	book := Book{}
+	err := repo.FindWhere(ctx, &book, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("pages > ?", 250)
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, seedBooks[2], book, "expected %+v but got: %+v", seedBooks[2], book)
+
+	// unsupported
+	var books []Book
+	err = repo.List(ctx, &books, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("lower(title) = ?", "hello")
+	})
+	assert.Truef(t, errors.Is(err, ErrUnsupportedCriteria), "expected %+v but got: %+v", ErrUnsupportedCriteria, err)
+}
+
+func TestRepository_Delete(t *testing.T) {
+	ctx, repo, _ := seed(t)
+
+	n, err := repo.DeleteWhere(ctx, (*Book)(nil), func(q *bun.DeleteQuery) *bun.DeleteQuery {
+		return q.Where("title LIKE ?", "hello%")
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(2), n, "expected %+v but got: %+v", 2, n)
+
+	_, err = repo.DeleteByPK(ctx, &Book{Id: "book1"}, true)
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	n, err = repo.DeleteByPK(ctx, &[]Book{{Id: "book3"}}, true)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, int64(1), n, "expected %+v but got: %+v", 1, n)
+}
+
+func TestRepository_Transactional(t *testing.T) {
+	ctx, repo, seedBooks := seed(t)
+
+	errRollback := errors.New("rollback")
+	err := repo.Transactional(ctx, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.NewWithTx(tx).DeleteByPK(ctx, &Book{Id: "book1"}, true); err != nil {
+			return err
+		}
+		if _, err := repo.NewWithTx(tx).Create(ctx, &Book{Id: "book9"}, false); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.Equalf(t, errRollback, err, "expected %+v but got: %+v", errRollback, err)
+
+	var books []Book
+	err = repo.List(ctx, &books)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, seedBooks, books, "expected %+v but got: %+v", seedBooks, books)
+
+	// upserts are rolled back too
+	err = repo.Transactional(ctx, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := repo.NewWithTx(tx).Upsert(ctx, &[]Book{{Id: "book2", Title: "changed"}}, datastore.UpsertOptions{}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.Equalf(t, errRollback, err, "expected %+v but got: %+v", errRollback, err)
+
+	book := Book{Id: "book2"}
+	err = repo.FindByPK(ctx, &book)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equalf(t, seedBooks[1], book, "expected %+v but got: %+v", seedBooks[1], book)
+
+	// a failing upsert leaves no row of its batch
+	_, err = repo.Upsert(ctx, &[]Book{{Id: "book7", Title: "new"}, {Id: "book1", Title: "duplicate"}}, datastore.UpsertOptions{
+		ConflictColumns: []string{"title"},
+	})
+	assert.Truef(t, errors.Is(err, ErrDuplicateKey), "expected %+v but got: %+v", ErrDuplicateKey, err)
+
+	err = repo.FindByPK(ctx, &Book{Id: "book7"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+
+	// committed
+	err = repo.Transactional(ctx, func(ctx context.Context, tx bun.Tx) error {
+		_, err := repo.NewWithTx(tx).DeleteByPK(ctx, &Book{Id: "book1"}, true)
+		return err
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+
+	err = repo.FindByPK(ctx, &Book{Id: "book1"})
+	assert.Equalf(t, sql.ErrNoRows, err, "expected %+v but got: %+v", sql.ErrNoRows, err)
+}
+
+func TestRepository_Each_And_EachChunk(t *testing.T) {
+	ctx, repo, seedBooks := seed(t)
+
+	var ids []string
+	book := Book{}
+	err := repo.Each(ctx, &book, func(ctx context.Context) error {
+		ids = append(ids, book.Id)
+		return nil
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, []string{"book1", "book2", "book3"}, ids)
+
+	var chunks [][]Book
+	var books []Book
+	err = repo.EachChunk(ctx, &books, 2, func(ctx context.Context) error {
+		chunks = append(chunks, books)
+		return nil
+	})
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.Equal(t, [][]Book{seedBooks[0:2], seedBooks[2:3]}, chunks)
+}
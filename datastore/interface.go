@@ -10,28 +10,37 @@ import (
 	"github.com/uptrace/bun"
 )
 
-// IDBHelper is an interface that provides quick helpers for handling database operations
+// IDBHelper is an interface that provides quick helpers for handling database operations.
+// The write operations return the number of rows affected.
 type IDBRepository interface {
 	// Migration create ONE OR MORE tables ONLY when they dont exists.
 	Migrate(ctx context.Context, modelsPtr ...any) error
-	// UpdateByPK updates a record by their primary-key (set in struct)
-	Update(ctx context.Context, modelsPtr any) error
+	// UpdateByPK updates a record by their primary-key (set in struct).
+	// mustExist makes it fail with a not-found error when no row matched
+	Update(ctx context.Context, modelsPtr any, mustExist bool) (int64, error)
 	// UpdateBulk updates multiple rows via primarykey
-	UpdateBulk(ctx context.Context, modelPtr any) error
-	// Upsert updates ONE OR MORE record. if the record doesn't exist, it inserts it.
-	Upsert(ctx context.Context, modelsPtr any) error
+	UpdateBulk(ctx context.Context, modelPtr any) (int64, error)
+	// Upsert inserts ONE OR MORE record, updating (or skipping) the existing ones as per opts.
+	Upsert(ctx context.Context, modelsPtr any, opts UpsertOptions) (UpsertResult, error)
 	// Create inserts ONE OR MORE record.
-	Create(ctx context.Context, modelPtr any, ignoreDupicates bool) error
+	Create(ctx context.Context, modelPtr any, ignoreDupicates bool) (int64, error)
+	// CreateInBatches inserts MANY records, batchSize records per statement, within a transaction.
+	CreateInBatches(ctx context.Context, modelsPtr any, batchSize int) (int64, error)
 	// FindByPK gets ONE record by primary-key (set in struct). [limit 1]
 	FindByPK(ctx context.Context, modelPtr any) error
 	// FindByColumn gets record(s) via supplied criteria.  [limit 1]
 	FindWhere(ctx context.Context, modelPtr any, sc ...SelectCriteria) error
 	// List records of a table via criteria. Useful for loading settings from db
 	List(ctx context.Context, modelPtr any, sc ...SelectCriteria) error
-	// DeleteByPK deletes record(s) using primary key in struct
-	DeleteByPK(ctx context.Context, modelsPtr any) error
+	// Each streams records via criteria ONE row at a time into modelPtr, calling fn after every row.
+	Each(ctx context.Context, modelPtr any, fn func(ctx context.Context) error, sc ...SelectCriteria) error
+	// EachChunk loads records via criteria in chunks (paged by primary-key), calling fn after every chunk.
+	EachChunk(ctx context.Context, modelsPtr any, chunkSize int, fn func(ctx context.Context) error, sc ...SelectCriteria) error
+	// DeleteByPK deletes record(s) using primary key in struct.
+	// mustExist makes it fail with a not-found error when no row matched
+	DeleteByPK(ctx context.Context, modelsPtr any, mustExist bool) (int64, error)
 	// DeleteWhere deletes records(s) via criteria
-	DeleteWhere(ctx context.Context, modelsPtr any, dc ...DeleteCriteria) error
+	DeleteWhere(ctx context.Context, modelsPtr any, dc ...DeleteCriteria) (int64, error)
 
 	// NewWithTx returns a clone of DBHelper, HOWEVER OVERRIDING the dbConnection with a db-Transaction conn
 	// as the new dbConnection
@@ -47,7 +56,8 @@ type IDBRepository interface {
 	// 			return err
 	// 		}
 
-	// 		return NewWithTx(tx).Create(ctx, &seedBooks, true)
+	// 		_, err = NewWithTx(tx).Create(ctx, &seedBooks, true)
+	// 		return err
 	// 	})
 	Transactional(ctx context.Context, fn func(ctx context.Context, tx bun.Tx) error) error
 }
@@ -59,11 +69,40 @@ type ICache interface {
 	Has(ctx context.Context, key string) bool
 	// Get gets a key from the cache
 	Get(ctx context.Context, key string, dest any) error
-	// Set sets a key to the cache
-	Set(ctx context.Context, key string, val any, ttl time.Duration) error
+	// Set sets a key to the cache for ttl (0 never expires, like every ttl of ICache), eg: tagged with
+	// WithTags
+	Set(ctx context.Context, key string, val any, ttl time.Duration, opts ...SetOption) error
 	// Del deletes a key from the cache
 	Del(ctx context.Context, key string) error
-	// Clear used to flush/clear the cache
+	// SetIfAbsent sets a key only if it doesn't exist. It reports whether it was set.
+	SetIfAbsent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error)
+	// SetIfPresent sets a key only if it exists. It reports whether it was set.
+	SetIfPresent(ctx context.Context, key string, val any, ttl time.Duration) (bool, error)
+	// CompareAndSwap sets a key to newVal only if its value is oldVal, comparing their encoding (beware
+	// of maps: gob & msgpack encode them in random order). It reports whether it was set.
+	CompareAndSwap(ctx context.Context, key string, oldVal, newVal any, ttl time.Duration) (bool, error)
+	// GetAndDelete gets a key from the cache & deletes it, atomically
+	GetAndDelete(ctx context.Context, key string, dest any) error
+	// Incr increments the counter of key by 1. See IncrBy.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrBy increments the counter of key by delta (negative to decrement) & returns its new value,
+	// atomically. A new counter starts at 0 & expires after ttl (0 never): incrementing it doesn't
+	// extend its ttl. Counters aren't encoded with the codec: read them with IncrBy 0, not Get.
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Decr decrements the counter of key by 1. See IncrBy.
+	Decr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// InvalidateTag deletes the keys set with any of tags (see WithTags)
+	InvalidateTag(ctx context.Context, tags ...string) error
+	// DelPrefix deletes the keys starting with prefix
+	DelPrefix(ctx context.Context, prefix string) error
+	// GetMany gets keys from the cache into the map pointed by destMapPtr (eg: *map[string]User), in as
+	// few round trips as possible. It returns the missing keys, eg: to load from the database.
+	GetMany(ctx context.Context, keys []string, destMapPtr any) (missing []string, err error)
+	// SetMany sets the keys of items to the cache
+	SetMany(ctx context.Context, items map[string]any, ttl time.Duration) error
+	// DelMany deletes keys from the cache
+	DelMany(ctx context.Context, keys ...string) error
+	// Clear used to flush/clear the keys of the cache (on redis: of its namespace, see Rueidis.WithNamespace)
 	Clear(ctx context.Context) error
 	// Close closes the connection
 	Close() error
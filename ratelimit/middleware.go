@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/otyang/go-pkg/response"
+)
+
+// KeyFunc returns the key limiting a request, eg: its client IP or user
+type KeyFunc func(r *http.Request) string
+
+// ByIP keys the requests by the IP of the client (the remote address of the connection: behind a
+// proxy, key by the forwarded IP the proxy sets instead)
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware limits the requests per key of keyFunc, responding a 429 response.TooManyRequests with a
+// Retry-After header when denied. The X-RateLimit-Limit & X-RateLimit-Remaining headers are set on
+// every response. Requests are let through when the limiter fails (eg: the cache is unavailable).
+func Middleware(limiter Limiter, keyFunc KeyFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := limiter.Allow(r.Context(), keyFunc(r))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+
+			if !res.Allowed {
+				retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+				response.JSON(w, response.TooManyRequests("", ""), map[string]string{
+					"Retry-After": strconv.Itoa(retryAfter),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
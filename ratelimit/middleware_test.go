@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/otyang/go-pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	limiter, err := NewFixedWindow(datastore.NewGoCache(), 1, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	limiter.now = newClock().Now
+	handler := Middleware(limiter, ByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("10.0.0.1:1234")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	// same IP, another port
+	w = request("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	var body response.Response
+	err = json.NewDecoder(w.Body).Decode(&body)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	assert.False(t, body.Success)
+	assert.Equal(t, "too_many_requests", *body.ErrorCode)
+
+	// another IP
+	w = request("10.0.0.2:1234")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,209 @@
+// Package ratelimit limits the rate of events (eg: requests) per key, with the counters stored in a
+// datastore.ICache: in-process with GoCache, or shared by the instances with Rueidis.
+//
+//	limiter, err := ratelimit.NewSlidingWindow(cache, 100, time.Minute)
+//	if err != nil {
+//		return err
+//	}
+//	router.Use(ratelimit.Middleware(limiter, ratelimit.ByIP))
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/otyang/go-pkg/datastore"
+)
+
+var (
+	_ Limiter = (*FixedWindow)(nil)
+	_ Limiter = (*SlidingWindow)(nil)
+	_ Limiter = (*TokenBucket)(nil)
+)
+
+// ErrContention is returned by TokenBucket when its state keeps changing under concurrent updates
+var ErrContention = errors.New("rate limit state contended, try again")
+
+// Limiter decides whether an event of key is allowed
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// Result is the decision of a Limiter
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // when denied: the wait before an event may be allowed
+}
+
+// FixedWindow allows limit events per window, windows starting at fixed times (multiples of window).
+// It is the cheapest limiter (ONE increment per event), but allows up to 2*limit events around the
+// start of a window.
+type FixedWindow struct {
+	cache  datastore.ICache
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewFixedWindow returns a limiter allowing limit events per window
+func NewFixedWindow(cache datastore.ICache, limit int, window time.Duration) (*FixedWindow, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, fmt.Errorf("invalid fixed window settings: limit %d, window %s", limit, window)
+	}
+	return &FixedWindow{cache: cache, limit: limit, window: window, now: time.Now}, nil
+}
+
+func (l *FixedWindow) Allow(ctx context.Context, key string) (Result, error) {
+	index, elapsed := windowOf(l.now(), l.window)
+
+	n, err := l.cache.Incr(ctx, windowKey("fw", key, index), l.window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Allowed: n <= int64(l.limit), Limit: l.limit, Remaining: remaining(l.limit, n)}
+	if !res.Allowed {
+		res.RetryAfter = l.window - elapsed
+	}
+	return res, nil
+}
+
+// SlidingWindow allows limit events in any window, approximating the events of the sliding window
+// from the counts of the current & previous fixed windows, weighted by their overlap with it. Denied
+// events aren't counted.
+type SlidingWindow struct {
+	cache  datastore.ICache
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindow returns a limiter allowing limit events in any window
+func NewSlidingWindow(cache datastore.ICache, limit int, window time.Duration) (*SlidingWindow, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, fmt.Errorf("invalid sliding window settings: limit %d, window %s", limit, window)
+	}
+	return &SlidingWindow{cache: cache, limit: limit, window: window, now: time.Now}, nil
+}
+
+func (l *SlidingWindow) Allow(ctx context.Context, key string) (Result, error) {
+	index, elapsed := windowOf(l.now(), l.window)
+	currentKey := windowKey("sw", key, index)
+
+	// the counters must outlive the next window, weighting them as the previous one
+	current, err := l.cache.Incr(ctx, currentKey, 2*l.window)
+	if err != nil {
+		return Result{}, err
+	}
+	previous, err := l.cache.IncrBy(ctx, windowKey("sw", key, index-1), 0, 2*l.window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(l.window)
+	estimate := int64(math.Floor(float64(previous)*weight)) + current
+
+	res := Result{Allowed: estimate <= int64(l.limit), Limit: l.limit, Remaining: remaining(l.limit, estimate)}
+	if !res.Allowed {
+		if _, err := l.cache.Decr(ctx, currentKey, 2*l.window); err != nil {
+			return Result{}, err
+		}
+		res.RetryAfter = l.window - elapsed
+	}
+	return res, nil
+}
+
+// TokenBucket allows bursts of capacity events, refilling one token every refillEvery: the sustained
+// rate is one event per refillEvery.
+//
+// Its state is updated with compare-and-swap (datastore.ICache.CompareAndSwap): under heavy contention
+// of a key, Allow may fail with ErrContention.
+type TokenBucket struct {
+	cache       datastore.ICache
+	capacity    int
+	refillEvery time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+// bucketState is the state of a bucket: its tokens when updated (unix nanoseconds)
+type bucketState struct {
+	Tokens  float64
+	Updated int64
+}
+
+// NewTokenBucket returns a limiter of buckets of capacity tokens, refilled one token every refillEvery
+func NewTokenBucket(cache datastore.ICache, capacity int, refillEvery time.Duration) (*TokenBucket, error) {
+	if capacity <= 0 || refillEvery <= 0 {
+		return nil, fmt.Errorf("invalid token bucket settings: capacity %d, refillEvery %s", capacity, refillEvery)
+	}
+	return &TokenBucket{cache: cache, capacity: capacity, refillEvery: refillEvery, maxAttempts: 10, now: time.Now}, nil
+}
+
+func (l *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	key = "ratelimit:tb:" + key
+	// a bucket untouched until full again is as good as missing
+	ttl := time.Duration(l.capacity)*l.refillEvery + time.Second
+
+	for attempt := 0; attempt < l.maxAttempts; attempt++ {
+		var state bucketState
+		err := l.cache.Get(ctx, key, &state)
+		found := err == nil
+		if err != nil && !datastore.IsErrNotFound(err) {
+			return Result{}, err
+		}
+
+		now := l.now()
+		tokens := float64(l.capacity)
+		if found {
+			refilled := float64(now.UnixNano()-state.Updated) / float64(l.refillEvery)
+			tokens = math.Min(tokens, state.Tokens+refilled)
+		}
+
+		if tokens < 1 {
+			return Result{
+				Limit:      l.capacity,
+				RetryAfter: time.Duration((1 - tokens) * float64(l.refillEvery)),
+			}, nil
+		}
+
+		next := bucketState{Tokens: tokens - 1, Updated: now.UnixNano()}
+		var swapped bool
+		if found {
+			swapped, err = l.cache.CompareAndSwap(ctx, key, state, next, ttl)
+		} else {
+			swapped, err = l.cache.SetIfAbsent(ctx, key, next, ttl)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		if swapped {
+			return Result{Allowed: true, Limit: l.capacity, Remaining: int(next.Tokens)}, nil
+		}
+	}
+
+	return Result{}, ErrContention
+}
+
+// windowOf returns the index of the window of t, & the time elapsed in it
+func windowOf(t time.Time, window time.Duration) (int64, time.Duration) {
+	nanos := t.UnixNano()
+	return nanos / int64(window), time.Duration(nanos % int64(window))
+}
+
+func windowKey(kind, key string, index int64) string {
+	return "ratelimit:" + kind + ":" + key + ":" + strconv.FormatInt(index, 10)
+}
+
+func remaining(limit int, n int64) int {
+	if n >= int64(limit) {
+		return 0
+	}
+	return limit - int(n)
+}
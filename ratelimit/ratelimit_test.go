@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/otyang/go-pkg/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a manual clock for the limiters, starting mid-window (of a minute)
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2023, 6, 1, 12, 0, 30, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func caches(t *testing.T) map[string]datastore.ICache {
+	redis := miniredis.RunT(t)
+	return map[string]datastore.ICache{
+		"GoCache": datastore.NewGoCache(),
+		"Rueidis": datastore.NewRueidis([]string{redis.Addr()}, "", true),
+	}
+}
+
+func allowN(t *testing.T, limiter Limiter, key string, n int) []Result {
+	results := make([]Result, n)
+	for i := range results {
+		res, err := limiter.Allow(context.Background(), key)
+		assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+		results[i] = res
+	}
+	return results
+}
+
+func TestFixedWindow(t *testing.T) {
+	for name, cache := range caches(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newClock()
+			limiter, err := NewFixedWindow(cache, 3, time.Minute)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+			limiter.now = clock.Now
+
+			results := allowN(t, limiter, "fw", 4)
+			assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 2}, results[0])
+			assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, results[2])
+			assert.Equal(t, Result{Limit: 3, RetryAfter: 30 * time.Second}, results[3])
+
+			// keys are limited separately
+			assert.True(t, allowN(t, limiter, "fw:other", 1)[0].Allowed)
+
+			// the next window starts afresh
+			clock.Advance(30 * time.Second)
+			assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 2}, allowN(t, limiter, "fw", 1)[0])
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	for name, cache := range caches(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newClock()
+			limiter, err := NewSlidingWindow(cache, 3, time.Minute)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+			limiter.now = clock.Now
+
+			results := allowN(t, limiter, "sw", 5)
+			assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 2}, results[0])
+			assert.True(t, results[2].Allowed)
+			assert.Equal(t, Result{Limit: 3, RetryAfter: 30 * time.Second}, results[3])
+
+			// denied events aren't counted
+			index, _ := windowOf(clock.Now(), time.Minute)
+			n, _ := cache.IncrBy(context.Background(), windowKey("sw", "sw", index), 0, time.Minute)
+			assert.Equal(t, int64(3), n)
+
+			// the previous window is weighted by its overlap with the sliding window: 3 * 3/4
+			clock.Advance(45 * time.Second)
+			results = allowN(t, limiter, "sw", 2)
+			assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, results[0])
+			assert.False(t, results[1].Allowed)
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	for name, cache := range caches(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newClock()
+			limiter, err := NewTokenBucket(cache, 2, 100*time.Millisecond)
+			assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+			limiter.now = clock.Now
+
+			results := allowN(t, limiter, "tb", 3)
+			assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1}, results[0])
+			assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0}, results[1])
+			assert.Equal(t, Result{Limit: 2, RetryAfter: 100 * time.Millisecond}, results[2])
+
+			// refilled
+			clock.Advance(120 * time.Millisecond)
+			assert.True(t, allowN(t, limiter, "tb", 1)[0].Allowed)
+			assert.False(t, allowN(t, limiter, "tb", 1)[0].Allowed)
+		})
+	}
+}
+
+func TestLimiters_Concurrency(t *testing.T) {
+	clock := newClock()
+	fixed, err := NewFixedWindow(datastore.NewGoCache(), 10, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	fixed.now = clock.Now
+	sliding, err := NewSlidingWindow(datastore.NewGoCache(), 10, time.Minute)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	sliding.now = clock.Now
+	bucket, err := NewTokenBucket(datastore.NewGoCache(), 10, time.Hour)
+	assert.Equalf(t, nil, err, "expected %+v but got: %+v", nil, err)
+	bucket.now = clock.Now
+
+	limiters := map[string]Limiter{"FixedWindow": fixed, "SlidingWindow": sliding, "TokenBucket": bucket}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			var (
+				wg      sync.WaitGroup
+				allowed int32
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 3; j++ {
+						res, err := limiter.Allow(context.Background(), "concurrent")
+						if errors.Is(err, ErrContention) {
+							j--
+							continue
+						}
+						if res.Allowed {
+							atomic.AddInt32(&allowed, 1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int32(10), atomic.LoadInt32(&allowed))
+		})
+	}
+}
+
+func TestNewLimiters_Invalid(t *testing.T) {
+	cache := datastore.NewGoCache()
+
+	_, err := NewFixedWindow(cache, 1, 0)
+	assert.Errorf(t, err, "expected an error for a zero window")
+	_, err = NewFixedWindow(cache, 0, time.Minute)
+	assert.Errorf(t, err, "expected an error for a zero limit")
+
+	_, err = NewSlidingWindow(cache, 1, -time.Minute)
+	assert.Errorf(t, err, "expected an error for a negative window")
+	_, err = NewSlidingWindow(cache, -1, time.Minute)
+	assert.Errorf(t, err, "expected an error for a negative limit")
+
+	_, err = NewTokenBucket(cache, 0, time.Second)
+	assert.Errorf(t, err, "expected an error for a zero capacity")
+	_, err = NewTokenBucket(cache, 1, 0)
+	assert.Errorf(t, err, "expected an error for a zero refill interval")
+}
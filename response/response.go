@@ -138,6 +138,18 @@ func Conflict(msg string, errorCode string) *Response {
 	)
 }
 
+// TooManyRequests creates a error response with (HTTP 429) code
+func TooManyRequests(msg string, errorCode string) *Response {
+	if msg == "" {
+		msg = "Too many requests, please try again later"
+	}
+	return NewError(
+		http.StatusTooManyRequests,
+		msg,
+		setErrCode(errorCode, "too_many_requests"),
+	)
+}
+
 // InternalServerError creates a error response with (HTTP 500)code
 func InternalServerError(msg string, errorCode string) *Response {
 	if msg == "" {